@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"github.com/acicn/minit/pkg/mlog"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	cgroupCPUPeriod = 100000
+
+	// cgroupEventsInterval 进程运行期间检查 memory.events 的间隔
+	cgroupEventsInterval = time.Second
+)
+
+// cgroupSetting cgroup 控制文件及其内容
+type cgroupSetting struct {
+	File  string
+	Value string
+}
+
+// cgroupSettings 将 memory_max, memory_high, cpu_max, pids_max 和 io_weight 字段转换为 cgroup v2 控制文件
+func (o ExecuteOptions) cgroupSettings() (settings []cgroupSetting, err error) {
+	var val string
+	if o.MemoryMax != "" {
+		if val, err = parseCgroupBytes(o.MemoryMax); err != nil {
+			err = fmt.Errorf("无效的 memory_max: %s", err.Error())
+			return
+		}
+		settings = append(settings, cgroupSetting{File: "memory.max", Value: val})
+	}
+	if o.MemoryHigh != "" {
+		if val, err = parseCgroupBytes(o.MemoryHigh); err != nil {
+			err = fmt.Errorf("无效的 memory_high: %s", err.Error())
+			return
+		}
+		settings = append(settings, cgroupSetting{File: "memory.high", Value: val})
+	}
+	if o.CPUMax != "" {
+		if val, err = parseCgroupCPU(o.CPUMax); err != nil {
+			err = fmt.Errorf("无效的 cpu_max: %s", err.Error())
+			return
+		}
+		settings = append(settings, cgroupSetting{File: "cpu.max", Value: val})
+	}
+	if o.PidsMax != "" {
+		val = strings.TrimSpace(o.PidsMax)
+		if val != "max" {
+			if n, err1 := strconv.ParseUint(val, 10, 64); err1 != nil || n == 0 {
+				err = fmt.Errorf("无效的 pids_max: %s", o.PidsMax)
+				return
+			}
+		}
+		settings = append(settings, cgroupSetting{File: "pids.max", Value: val})
+	}
+	if o.IOWeight != 0 {
+		if o.IOWeight < 1 || o.IOWeight > 10000 {
+			err = fmt.Errorf("无效的 io_weight: %d，必须在 1 到 10000 之间", o.IOWeight)
+			return
+		}
+		settings = append(settings, cgroupSetting{File: "io.weight", Value: "default " + strconv.Itoa(o.IOWeight)})
+	}
+	return
+}
+
+// parseCgroupBytes 解析 512M, 1G, 1048576 或者 max 格式的内存大小，单位为 1024 进制
+func parseCgroupBytes(s string) (val string, err error) {
+	s = strings.TrimSpace(s)
+	if s == "max" {
+		return s, nil
+	}
+	num := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "B"), "I")
+	var unit uint64 = 1
+	if len(num) > 0 {
+		switch num[len(num)-1] {
+		case 'K':
+			unit = 1 << 10
+		case 'M':
+			unit = 1 << 20
+		case 'G':
+			unit = 1 << 30
+		case 'T':
+			unit = 1 << 40
+		}
+		if unit != 1 {
+			num = num[:len(num)-1]
+		}
+	}
+	var n float64
+	if n, err = strconv.ParseFloat(strings.TrimSpace(num), 64); err != nil || n <= 0 {
+		err = fmt.Errorf("无法解析 %s，格式为 512M, 1G 或者 max", s)
+		return
+	}
+	val = strconv.FormatUint(uint64(n*float64(unit)), 10)
+	return
+}
+
+// parseCgroupCPU 解析 CPU 上限，支持核心数 (比如 0.5)，max，或者原始的 "$QUOTA $PERIOD" 格式
+func parseCgroupCPU(s string) (val string, err error) {
+	s = strings.TrimSpace(s)
+	if s == "max" || strings.Contains(s, " ") {
+		return s, nil
+	}
+	var cores float64
+	if cores, err = strconv.ParseFloat(s, 64); err != nil || cores <= 0 {
+		err = fmt.Errorf("无法解析 %s，格式为核心数 (比如 0.5)，max，或者 \"50000 100000\"", s)
+		return
+	}
+	val = fmt.Sprintf("%d %d", int64(cores*cgroupCPUPeriod), cgroupCPUPeriod)
+	return
+}
+
+// unitCgroup 单元的 cgroup
+type unitCgroup struct {
+	dir string
+}
+
+// cgroupStat cgroup 的资源统计
+type cgroupStat struct {
+	CPUUsage   time.Duration
+	CPUUser    time.Duration
+	CPUSystem  time.Duration
+	MemoryPeak uint64
+	MemoryHigh uint64 // 超过 memory.high 的次数
+	OOMKill    uint64 // 被 OOM 终止的进程数
+}
+
+// readKeyValues 读取 cpu.stat, memory.events 格式的文件
+func (cg *unitCgroup) readKeyValues(name string) map[string]uint64 {
+	out := map[string]uint64{}
+	buf, err := ioutil.ReadFile(filepath.Join(cg.dir, name))
+	if err != nil {
+		return out
+	}
+	for _, line := range strings.Split(string(buf), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if n, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			out[fields[0]] = n
+		}
+	}
+	return out
+}
+
+func (cg *unitCgroup) stat() (s cgroupStat) {
+	cpu := cg.readKeyValues("cpu.stat")
+	s.CPUUsage = time.Duration(cpu["usage_usec"]) * time.Microsecond
+	s.CPUUser = time.Duration(cpu["user_usec"]) * time.Microsecond
+	s.CPUSystem = time.Duration(cpu["system_usec"]) * time.Microsecond
+	events := cg.readKeyValues("memory.events")
+	s.MemoryHigh = events["high"]
+	s.OOMKill = events["oom_kill"]
+	if buf, err := ioutil.ReadFile(filepath.Join(cg.dir, "memory.peak")); err == nil {
+		s.MemoryPeak, _ = strconv.ParseUint(strings.TrimSpace(string(buf)), 10, 64)
+	}
+	return
+}
+
+// watchEvents 定期检查 memory.events，cgroup 内有进程被 OOM 终止时立即记录到单元日志，直到 done 关闭；
+// 返回的 seen 为已经记录过的统计，传递给 report 以避免重复记录
+func (cg *unitCgroup) watchEvents(seen cgroupStat, interval time.Duration, done chan struct{}, logger *mlog.Logger) (result chan cgroupStat) {
+	result = make(chan cgroupStat, 1)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				result <- seen
+				return
+			case <-ticker.C:
+				events := cg.readKeyValues("memory.events")
+				if n := events["oom_kill"]; n > seen.OOMKill {
+					logger.Errorf("内存用量超过 memory_max，%d 个进程被 OOM 终止", n-seen.OOMKill)
+					seen.OOMKill = n
+				}
+			}
+		}
+	}()
+	return
+}
+
+// report 将本次运行的资源统计记录到单元日志
+func (cg *unitCgroup) report(before cgroupStat, logger *mlog.Logger) {
+	after := cg.stat()
+	items := []string{
+		fmt.Sprintf("CPU 时间 %s (用户 %s, 系统 %s)",
+			(after.CPUUsage - before.CPUUsage).Round(time.Millisecond),
+			(after.CPUUser - before.CPUUser).Round(time.Millisecond),
+			(after.CPUSystem - before.CPUSystem).Round(time.Millisecond),
+		),
+	}
+	if after.MemoryPeak > 0 {
+		items = append(items, "内存峰值 "+formatBytes(after.MemoryPeak))
+	}
+	logger.Printf("资源统计: %s", strings.Join(items, ", "))
+	if n := after.MemoryHigh - before.MemoryHigh; n > 0 {
+		logger.Errorf("内存用量超过 memory_high %d 次，进程被限流", n)
+	}
+	if n := after.OOMKill - before.OOMKill; n > 0 {
+		logger.Errorf("内存用量超过 memory_max，%d 个进程被 OOM 终止", n)
+	}
+}
+
+// remove 删除 cgroup，仍有进程时会失败，忽略错误
+func (cg *unitCgroup) remove() {
+	_ = os.Remove(cg.dir)
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCgroupSettings(t *testing.T) {
+	settings, err := ExecuteOptions{
+		MemoryMax:  "512M",
+		MemoryHigh: "1.5GiB",
+		CPUMax:     "0.5",
+		PidsMax:    "100",
+		IOWeight:   200,
+	}.cgroupSettings()
+	require.NoError(t, err)
+	require.Equal(t, []cgroupSetting{
+		{File: "memory.max", Value: "536870912"},
+		{File: "memory.high", Value: "1610612736"},
+		{File: "cpu.max", Value: "50000 100000"},
+		{File: "pids.max", Value: "100"},
+		{File: "io.weight", Value: "default 200"},
+	}, settings)
+
+	settings, err = ExecuteOptions{MemoryMax: "max", CPUMax: "20000 50000", PidsMax: "max"}.cgroupSettings()
+	require.NoError(t, err)
+	require.Equal(t, []cgroupSetting{
+		{File: "memory.max", Value: "max"},
+		{File: "cpu.max", Value: "20000 50000"},
+		{File: "pids.max", Value: "max"},
+	}, settings)
+
+	settings, err = ExecuteOptions{}.cgroupSettings()
+	require.NoError(t, err)
+	require.Empty(t, settings)
+
+	for _, opts := range []ExecuteOptions{
+		{MemoryMax: "abc"},
+		{MemoryHigh: "-1M"},
+		{CPUMax: "0"},
+		{PidsMax: "0"},
+		{IOWeight: 10001},
+	} {
+		_, err = opts.cgroupSettings()
+		require.Error(t, err)
+	}
+}
+
+func TestUnitCgroupStat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minit-cgroup")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "cpu.stat"), []byte("usage_usec 1500000\nuser_usec 1000000\nsystem_usec 500000\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "memory.events"), []byte("low 0\nhigh 3\nmax 1\noom 1\noom_kill 2\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "memory.peak"), []byte("1048576\n"), 0644))
+
+	cg := &unitCgroup{dir: dir}
+	require.Equal(t, cgroupStat{
+		CPUUsage:   time.Millisecond * 1500,
+		CPUUser:    time.Second,
+		CPUSystem:  time.Millisecond * 500,
+		MemoryPeak: 1048576,
+		MemoryHigh: 3,
+		OOMKill:    2,
+	}, cg.stat())
+	require.Equal(t, "1.0MiB", formatBytes(1048576))
+	require.Equal(t, "512B", formatBytes(512))
+}
+
+func TestUnitCgroupWatchEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minit-cgroup")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logger := newTestLogger(t, dir)
+	defer logger.Close()
+
+	cg := &unitCgroup{dir: dir}
+	done := make(chan struct{})
+	result := cg.watchEvents(cgroupStat{OOMKill: 1}, time.Millisecond*10, done, logger)
+
+	// 进程运行期间子进程被 OOM 终止
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "memory.events"), []byte("oom 3\noom_kill 3\n"), 0644))
+	time.Sleep(time.Millisecond * 100)
+	close(done)
+	require.Equal(t, uint64(3), (<-result).OOMKill)
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "test.err.log"))
+	require.NoError(t, err)
+	require.Contains(t, string(buf), "2 个进程被 OOM 终止")
+}
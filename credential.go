@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// credential 运行进程的用户和组
+type credential struct {
+	Uid    uint32
+	Gid    uint32
+	Groups []uint32
+
+	Username string
+	Home     string
+}
+
+// Env 返回需要为进程设置的环境变量
+func (c *credential) Env() []string {
+	return []string{
+		"HOME=" + c.Home,
+		"USER=" + c.Username,
+		"LOGNAME=" + c.Username,
+	}
+}
+
+// lookupCredential 解析 user 和 supplementary_groups 字段，user 格式为 user 或者 user:group，均支持名称和数字
+func lookupCredential(spec string, supplementaryGroups []string) (cred *credential, err error) {
+	splits := strings.SplitN(strings.TrimSpace(spec), ":", 2)
+	cred = &credential{Home: "/"}
+
+	// 用户
+	var u *user.User
+	if u, err = lookupUser(splits[0]); err != nil {
+		return
+	}
+	if u != nil {
+		if cred.Uid, err = parseID(u.Uid); err != nil {
+			return
+		}
+		if cred.Gid, err = parseID(u.Gid); err != nil {
+			return
+		}
+		cred.Username = u.Username
+		if u.HomeDir != "" {
+			cred.Home = u.HomeDir
+		}
+	} else {
+		// 不存在于 /etc/passwd 中的数字用户，与 docker 的行为保持一致
+		if cred.Uid, err = parseID(splits[0]); err != nil {
+			return
+		}
+		cred.Gid = cred.Uid
+		cred.Username = splits[0]
+	}
+
+	// 主要组
+	if len(splits) == 2 {
+		if cred.Gid, err = lookupGroupID(splits[1]); err != nil {
+			return
+		}
+	}
+
+	// 附加组，未指定时使用用户所属的组
+	if supplementaryGroups != nil {
+		for _, name := range supplementaryGroups {
+			var gid uint32
+			if gid, err = lookupGroupID(name); err != nil {
+				return
+			}
+			cred.Groups = append(cred.Groups, gid)
+		}
+	} else if u != nil {
+		var gids []string
+		if gids, err = lookupUserGroupIDs(u); err != nil {
+			return
+		}
+		for _, id := range gids {
+			var gid uint32
+			if gid, err = parseID(id); err != nil {
+				return
+			}
+			cred.Groups = append(cred.Groups, gid)
+		}
+	}
+	return
+}
+
+// lookupUser 按照名称或者数字查找用户，数字用户不存在时返回 nil
+func lookupUser(name string) (u *user.User, err error) {
+	if name == "" {
+		err = fmt.Errorf("缺少用户名")
+		return
+	}
+	if _, err1 := parseID(name); err1 == nil {
+		if u, err = user.LookupId(name); err != nil {
+			if _, ok := err.(user.UnknownUserIdError); ok {
+				u, err = nil, nil
+			}
+		}
+		return
+	}
+	if u, err = user.Lookup(name); err != nil {
+		err = fmt.Errorf("用户 %s 不存在: %s", name, err.Error())
+	}
+	return
+}
+
+// lookupGroupID 按照名称或者数字查找组
+func lookupGroupID(name string) (gid uint32, err error) {
+	name = strings.TrimSpace(name)
+	if gid, err = parseID(name); err == nil {
+		return
+	}
+	var g *user.Group
+	if g, err = user.LookupGroup(name); err != nil {
+		err = fmt.Errorf("组 %s 不存在: %s", name, err.Error())
+		return
+	}
+	return parseID(g.Gid)
+}
+
+// lookupUserGroupIDs 查找用户所属的组，不使用 cgo 时从 /etc/group 中查找
+func lookupUserGroupIDs(u *user.User) (gids []string, err error) {
+	if gids, err = u.GroupIds(); err == nil {
+		return
+	}
+	gids, err = []string{u.Gid}, nil
+
+	var f *os.File
+	if f, err = os.Open("/etc/group"); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	defer f.Close()
+
+	// 格式为 name:password:gid:user1,user2
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if len(fields) != 4 || fields[2] == u.Gid {
+			continue
+		}
+		for _, member := range strings.Split(fields[3], ",") {
+			if strings.TrimSpace(member) == u.Username {
+				gids = append(gids, fields[2])
+				break
+			}
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+func parseID(s string) (id uint32, err error) {
+	var n uint64
+	if n, err = strconv.ParseUint(s, 10, 32); err != nil {
+		return
+	}
+	id = uint32(n)
+	return
+}
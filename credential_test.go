@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestLookupCredential(t *testing.T) {
+	cred, err := lookupCredential("root", nil)
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), cred.Uid)
+	require.Equal(t, uint32(0), cred.Gid)
+	require.Contains(t, cred.Groups, uint32(0))
+	require.Equal(t, []string{"HOME=/root", "USER=root", "LOGNAME=root"}, cred.Env())
+
+	cred, err = lookupCredential("0:1", []string{"root", "2"})
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), cred.Uid)
+	require.Equal(t, uint32(1), cred.Gid)
+	require.Equal(t, []uint32{0, 2}, cred.Groups)
+	require.Equal(t, "root", cred.Username)
+
+	// 不存在的数字用户
+	cred, err = lookupCredential("54321", nil)
+	require.NoError(t, err)
+	require.Equal(t, uint32(54321), cred.Uid)
+	require.Equal(t, uint32(54321), cred.Gid)
+	require.Empty(t, cred.Groups)
+	require.Equal(t, "/", cred.Home)
+
+	_, err = lookupCredential("minit-no-such-user", nil)
+	require.Error(t, err)
+	_, err = lookupCredential("root:minit-no-such-group", nil)
+	require.Error(t, err)
+	_, err = lookupCredential("", nil)
+	require.Error(t, err)
+}
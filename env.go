@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// environment 有序的环境变量集合，后设置的值覆盖先设置的值
+type environment struct {
+	keys   []string
+	values map[string]string
+}
+
+func newEnvironment() *environment {
+	return &environment{values: map[string]string{}}
+}
+
+func (e *environment) Set(key, value string) {
+	if _, ok := e.values[key]; !ok {
+		e.keys = append(e.keys, key)
+	}
+	e.values[key] = value
+}
+
+// SetStrings 设置 KEY=VALUE 格式的环境变量
+func (e *environment) SetStrings(items []string) {
+	for _, item := range items {
+		splits := strings.SplitN(item, "=", 2)
+		if len(splits) == 2 {
+			e.Set(splits[0], splits[1])
+		}
+	}
+}
+
+func (e *environment) Get(key string) string {
+	return e.values[key]
+}
+
+// Expand 使用当前的环境变量展开 $VAR 和 ${VAR}
+func (e *environment) Expand(s string) string {
+	return os.Expand(s, e.Get)
+}
+
+func (e *environment) Strings() []string {
+	items := make([]string, 0, len(e.keys))
+	for _, key := range e.keys {
+		items = append(items, key+"="+e.values[key])
+	}
+	return items
+}
+
+// buildEnvironment 按照 clear_env, inherit_env, env_file 和 env 字段，构建进程的环境变量
+func buildEnvironment(opts ExecuteOptions, cred *credential) (env *environment, err error) {
+	env = newEnvironment()
+
+	// minit 自身的环境变量
+	for _, item := range os.Environ() {
+		splits := strings.SplitN(item, "=", 2)
+		if len(splits) != 2 {
+			continue
+		}
+		if opts.ClearEnv && !matchEnvPatterns(opts.InheritEnv, splits[0]) {
+			continue
+		}
+		env.Set(splits[0], splits[1])
+	}
+
+	// 用户信息
+	if cred != nil {
+		env.SetStrings(cred.Env())
+	}
+
+	// 环境变量文件，- 开头表示文件不存在时忽略
+	for _, name := range opts.EnvFile {
+		name = strings.TrimSpace(name)
+		optional := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+
+		var f *os.File
+		if f, err = os.Open(name); err != nil {
+			if optional && os.IsNotExist(err) {
+				err = nil
+				continue
+			}
+			return
+		}
+		var items []string
+		items, err = parseEnvFile(f)
+		_ = f.Close()
+		if err != nil {
+			err = fmt.Errorf("无法解析环境变量文件 %s: %s", name, err.Error())
+			return
+		}
+		env.SetStrings(items)
+	}
+
+	// env 字段，按照键名排序，使用已有的环境变量展开
+	keys := make([]string, 0, len(opts.Env))
+	for key := range opts.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := map[string]string{}
+	for _, key := range keys {
+		values[key] = env.Expand(opts.Env[key])
+	}
+	for _, key := range keys {
+		env.Set(key, values[key])
+	}
+
+	// 控制器设置的环境变量
+	env.SetStrings(opts.extraEnv)
+	return
+}
+
+// matchEnvPatterns 检查环境变量名称是否匹配 inherit_env 字段，支持通配符
+func matchEnvPatterns(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.TrimSpace(pattern), key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEnvFile 解析 dotenv 格式的环境变量文件，支持注释，export 前缀和引号
+func parseEnvFile(r io.Reader) (items []string, err error) {
+	scanner := bufio.NewScanner(r)
+	var n int
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		splits := strings.SplitN(line, "=", 2)
+		if len(splits) != 2 || strings.TrimSpace(splits[0]) == "" {
+			err = fmt.Errorf("第 %d 行格式错误: %s", n, line)
+			return
+		}
+		key, value := strings.TrimSpace(splits[0]), strings.TrimSpace(splits[1])
+		if len(value) >= 2 {
+			switch {
+			case value[0] == '"' && value[len(value)-1] == '"':
+				if value, err = strconv.Unquote(value); err != nil {
+					err = fmt.Errorf("第 %d 行格式错误: %s", n, line)
+					return
+				}
+			case value[0] == '\'' && value[len(value)-1] == '\'':
+				value = value[1 : len(value)-1]
+			}
+		}
+		items = append(items, key+"="+value)
+	}
+	err = scanner.Err()
+	return
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	items, err := parseEnvFile(strings.NewReader(`
+# 注释
+A=1
+export B = hello world
+C="line1\nline2"
+D='$NOT_EXPANDED'
+E=
+`))
+	require.NoError(t, err)
+	require.Equal(t, []string{"A=1", "B=hello world", "C=line1\nline2", "D=$NOT_EXPANDED", "E="}, items)
+
+	_, err = parseEnvFile(strings.NewReader("INVALID"))
+	require.Error(t, err)
+}
+
+func TestBuildEnvironment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minit-env")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "app.env")
+	require.NoError(t, ioutil.WriteFile(file, []byte("DB_HOST=db\nDB_PORT=5432\n"), 0644))
+
+	require.NoError(t, os.Setenv("MINIT_TEST_KEEP", "keep"))
+	require.NoError(t, os.Setenv("MINIT_TEST_DROP", "drop"))
+	defer os.Unsetenv("MINIT_TEST_KEEP")
+	defer os.Unsetenv("MINIT_TEST_DROP")
+
+	env, err := buildEnvironment(ExecuteOptions{
+		ClearEnv:   true,
+		InheritEnv: []string{"MINIT_TEST_K*"},
+		EnvFile:    []string{file, "-" + filepath.Join(dir, "missing.env")},
+		Env: map[string]string{
+			"DB_URL":  "postgres://${DB_HOST}:${DB_PORT}",
+			"DB_PORT": "5433",
+		},
+		extraEnv: []string{"NOTIFY_SOCKET=@test"},
+	}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"MINIT_TEST_KEEP=keep",
+		"DB_HOST=db",
+		"DB_PORT=5433",
+		"DB_URL=postgres://db:5432",
+		"NOTIFY_SOCKET=@test",
+	}, env.Strings())
+	require.Equal(t, "db:5433", env.Expand("$DB_HOST:$DB_PORT"))
+
+	_, err = buildEnvironment(ExecuteOptions{EnvFile: []string{filepath.Join(dir, "missing.env")}}, nil)
+	require.Error(t, err)
+
+	env, err = buildEnvironment(ExecuteOptions{}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "drop", env.Get("MINIT_TEST_DROP"))
+}
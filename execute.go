@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/acicn/minit/pkg/mlog"
 	"github.com/acicn/minit/pkg/shellquote"
@@ -9,8 +10,21 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultStopTimeout = time.Second * 10
+
+	DefaultTTYRows = 24
+	DefaultTTYCols = 80
+
+	// outputDrainTimeout 进程退出后，等待读取剩余输出的最长时间
+	outputDrainTimeout = time.Second
 )
 
 var (
@@ -20,8 +34,13 @@ var (
 	}
 )
 
+const (
+	KillModeGroup  = "group"
+	KillModeLeader = "leader"
+)
+
 var (
-	childPids                 = map[int]bool{}
+	childPids                 = map[int]*childProcess{}
 	childPidsLock sync.Locker = &sync.Mutex{}
 )
 
@@ -30,35 +49,228 @@ type ExecuteOptions struct {
 	Shell   string   `yaml:"shell"`   // 使用 shell 来执行命令，比如 'bash'
 	Command []string `yaml:"command"` // 所有涉及命令执行的单元，指定命令执行的内容
 	Charset string   `yaml:"charset"` // output charset
+
+	Stdin  StdinOptions `yaml:"-"`      // 标准输入，null (默认), inherit, file 或者 data，由 Unit.UnmarshalYAML 解析 stdin 字段
+	Stdout string       `yaml:"stdout"` // 标准输出，log (默认), null, file:路径, truncate:路径 或者 unit:单元名称
+	Stderr string       `yaml:"stderr"` // 标准错误，格式同 stdout
+
+	TTY     bool `yaml:"tty"`      // 为进程分配伪终端，标准输出和标准错误合并输出，适用于仅在终端下按行刷新输出的程序
+	TTYRows int  `yaml:"tty_rows"` // 伪终端的行数，默认 24
+	TTYCols int  `yaml:"tty_cols"` // 伪终端的列数，默认 80
+
+	StopSignal  string        `yaml:"stop_signal"`  // 停止进程时发送的信号，默认 SIGTERM
+	StopTimeout time.Duration `yaml:"stop_timeout"` // 停止进程时的等待时间，超时后发送 SIGKILL，默认 10s
+	KillMode    string        `yaml:"kill_mode"`    // 停止进程时信号的发送范围，group 发送给整个进程组 (默认)，leader 仅发送给主进程
+
+	ForwardSignals []string `yaml:"forward_signals"` // 转发给主进程的信号，比如 SIGHUP，或者使用 SIGUSR1:SIGUSR2 转换后转发
+
+	User                string   `yaml:"user"`                 // 运行进程的用户，格式为 user 或者 user:group，支持名称和数字，默认为 minit 的用户
+	SupplementaryGroups []string `yaml:"supplementary_groups"` // 运行进程的附加组，支持名称和数字，默认为用户所属的组
+
+	Env        map[string]string `yaml:"env"`         // 额外的环境变量，值可以使用 $VAR 引用其他环境变量
+	EnvFile    []string          `yaml:"env_file"`    // 环境变量文件，dotenv 格式，- 开头表示文件不存在时忽略
+	ClearEnv   bool              `yaml:"clear_env"`   // 不继承 minit 的环境变量
+	InheritEnv []string          `yaml:"inherit_env"` // clear_env 时仍然继承的环境变量，支持通配符，比如 AWS_*
+
+	RLimits map[string]string `yaml:"rlimits"` // 进程的资源限制，比如 NOFILE: 65535 或者 NOFILE: 1024:65535
+
+	MemoryMax  string `yaml:"memory_max"`  // cgroup v2 内存上限，比如 512M，超过后触发 OOM
+	MemoryHigh string `yaml:"memory_high"` // cgroup v2 内存限流阈值，超过后进程被限流并积极回收内存
+	CPUMax     string `yaml:"cpu_max"`     // cgroup v2 CPU 上限，核心数比如 0.5，或者 "50000 100000"
+	PidsMax    string `yaml:"pids_max"`    // cgroup v2 进程数上限
+	IOWeight   int    `yaml:"io_weight"`   // cgroup v2 IO 权重，1 ~ 10000，默认 100
+
+	Nice        *int   `yaml:"nice"`          // 进程的 nice 值，-20 ~ 19
+	IONiceClass string `yaml:"ionice_class"`  // IO 调度类型，realtime, best-effort 或者 idle
+	IONiceLevel *int   `yaml:"ionice_level"`  // IO 调度优先级，0 ~ 7，默认 4
+	OOMScoreAdj *int   `yaml:"oom_score_adj"` // 内存不足时被内核终止的倾向，-1000 ~ 1000，值越大越先被终止
+	CPUAffinity string `yaml:"cpu_affinity"`  // 绑定的 CPU，比如 0-3,6
+
+	Capabilities        []string `yaml:"capabilities"`         // 保留的能力，其余能力从 bounding set 中移除，比如 CAP_NET_BIND_SERVICE，空列表表示移除全部
+	AmbientCapabilities []string `yaml:"ambient_capabilities"` // 切换为非 root 用户后仍然保留的能力
+	NoNewPrivs          bool     `yaml:"no_new_privs"`         // 禁止进程通过 setuid 或者文件能力获得新的权限
+
+	ReadOnlyPaths  []string `yaml:"read_only_paths"`  // 使用 Landlock 限制进程只能读取和执行的路径，- 开头表示不存在时忽略
+	ReadWritePaths []string `yaml:"read_write_paths"` // 使用 Landlock 限制进程可以读写的路径，设置任一字段后，其他路径均无法访问
+
+	unitName string // 单元名称，用于创建 cgroup，由 main 设置
+
+	onStart    func(pid int) // 进程启动后的回调，由控制器设置
+	extraEnv   []string      // 额外的环境变量，由控制器设置，比如 NOTIFY_SOCKET
+	extraFiles []*os.File    // 额外传递给进程的文件，从 3 开始编号，由控制器设置
+	helper     helperOptions // 需要由辅助进程完成的设置，由控制器设置
 }
 
-func addPid(pid int) {
+// childProcess 由 execute 启动的子进程
+type childProcess struct {
+	pid      int
+	group    bool                              // 信号是否发送给整个进程组
+	forwards map[syscall.Signal]syscall.Signal // 信号转发规则
+	logger   *mlog.Logger
+}
+
+func (c *childProcess) signal(sig syscall.Signal) error {
+	return signalProcess(c.pid, sig, c.group)
+}
+
+// startChild 启动进程并记录 Pid，记录完成前持有锁，避免进程被 reapZombies 提前回收
+func startChild(cmd *exec.Cmd, child *childProcess) (err error) {
 	childPidsLock.Lock()
 	defer childPidsLock.Unlock()
-	childPids[pid] = true
+	if err = cmd.Start(); err != nil {
+		return
+	}
+	child.pid = cmd.Process.Pid
+	childPids[child.pid] = child
+	return
 }
 
-func removePid(pid int) {
+func removeChild(child *childProcess) {
 	childPidsLock.Lock()
 	defer childPidsLock.Unlock()
-	delete(childPids, pid)
+	delete(childPids, child.pid)
 }
 
-func notifyPIDs(sig os.Signal) {
+func notifyPIDs(sig syscall.Signal) {
 	childPidsLock.Lock()
 	defer childPidsLock.Unlock()
-	for pid, found := range childPids {
-		if found {
-			if process, _ := os.FindProcess(pid); process != nil {
-				_ = process.Signal(sig)
+	for _, child := range childPids {
+		_ = child.signal(sig)
+	}
+}
+
+// forwardSignal 按照 forward_signals 规则，将 minit 接收到的信号转发给子进程的主进程
+func forwardSignal(sig syscall.Signal) {
+	childPidsLock.Lock()
+	defer childPidsLock.Unlock()
+	for _, child := range childPids {
+		if target, ok := child.forwards[sig]; ok {
+			child.logger.Printf("转发信号 %s 为 %s", sig.String(), target.String())
+			_ = signalProcess(child.pid, target, false)
+		}
+	}
+}
+
+// parseForwardSignals 解析 forward_signals 字段，返回 接收信号 => 转发信号
+func parseForwardSignals(items []string) (forwards map[syscall.Signal]syscall.Signal, err error) {
+	forwards = map[syscall.Signal]syscall.Signal{}
+	for _, item := range items {
+		splits := strings.Split(item, ":")
+		if len(splits) > 2 {
+			err = fmt.Errorf("无效的信号转发规则: %s", item)
+			return
+		}
+		var src, dst syscall.Signal
+		if src, err = parseSignal(splits[0]); err != nil {
+			return
+		}
+		dst = src
+		if len(splits) == 2 {
+			if dst, err = parseSignal(splits[1]); err != nil {
+				return
 			}
 		}
+		if unforwardableSignals[src] {
+			err = fmt.Errorf("信号 %s 不能被转发", src.String())
+			return
+		}
+		forwards[src] = dst
 	}
+	return
 }
 
-func execute(opts ExecuteOptions, logger *mlog.Logger) (err error) {
+// parseSignal 解析信号名称，支持 SIGTERM, TERM 和数字形式
+func parseSignal(s string) (sig syscall.Signal, err error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if n, err1 := strconv.Atoi(s); err1 == nil && n > 0 {
+		sig = syscall.Signal(n)
+		return
+	}
+	var ok bool
+	if sig, ok = knownSignalNames[strings.TrimPrefix(s, "SIG")]; !ok {
+		err = fmt.Errorf("未知信号: %s", s)
+	}
+	return
+}
+
+// stopProcess 向进程发送停止信号，超时后发送 SIGKILL，并等待进程退出
+func stopProcess(child *childProcess, sig syscall.Signal, timeout time.Duration, chErr chan error, logger *mlog.Logger) (err error) {
+	deadline := time.Now().Add(timeout)
+
+	logger.Printf("发送信号 %s 停止进程", sig.String())
+	_ = child.signal(sig)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err = <-chErr:
+	case <-timer.C:
+		logger.Errorf("进程在 %s 内未退出，发送 SIGKILL", timeout.String())
+		_ = child.signal(syscall.SIGKILL)
+		err = <-chErr
+	}
+
+	checkProcessGroup(child, deadline, logger)
+	return
+}
+
+// checkProcessGroup 检查主进程退出后，进程组内是否还有存活的进程
+func checkProcessGroup(child *childProcess, deadline time.Time, logger *mlog.Logger) {
+	for {
+		members := findProcessGroupMembers(child.pid)
+		if len(members) == 0 {
+			return
+		}
+		// 进程组已经收到信号，等待其自行退出
+		if child.group && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond * 100)
+			continue
+		}
+		var names []string
+		for _, member := range members {
+			names = append(names, fmt.Sprintf("%d(%s)", member.Pid, member.Comm))
+		}
+		logger.Errorf("进程组 %d 中仍有存活进程: %s", child.pid, strings.Join(names, ", "))
+		if child.group {
+			logger.Errorf("向进程组 %d 发送 SIGKILL", child.pid)
+			_ = child.signal(syscall.SIGKILL)
+		}
+		return
+	}
+}
+
+func execute(ctx context.Context, opts ExecuteOptions, logger *mlog.Logger) (err error) {
 	argv := make([]string, 0)
 
+	// 检查 opts.StopSignal
+	stopSignal := syscall.SIGTERM
+	if opts.StopSignal != "" {
+		if stopSignal, err = parseSignal(opts.StopSignal); err != nil {
+			err = fmt.Errorf("无法处理 stop_signal 参数，请检查: %s", err.Error())
+			return
+		}
+	}
+	stopTimeout := opts.StopTimeout
+	if stopTimeout <= 0 {
+		stopTimeout = DefaultStopTimeout
+	}
+
+	// 检查 opts.KillMode
+	switch opts.KillMode {
+	case "", KillModeGroup, KillModeLeader:
+	default:
+		err = fmt.Errorf("未知的 kill_mode: %s", opts.KillMode)
+		return
+	}
+
+	// 检查 opts.ForwardSignals
+	var forwards map[syscall.Signal]syscall.Signal
+	if forwards, err = parseForwardSignals(opts.ForwardSignals); err != nil {
+		err = fmt.Errorf("无法处理 forward_signals 参数，请检查: %s", err.Error())
+		return
+	}
+
 	// 检查 opts.Dir
 	if opts.Dir != "" {
 		var info os.FileInfo
@@ -72,6 +284,67 @@ func execute(opts ExecuteOptions, logger *mlog.Logger) (err error) {
 		}
 	}
 
+	// 检查 opts.User
+	var cred *credential
+	if opts.User != "" {
+		if cred, err = lookupCredential(opts.User, opts.SupplementaryGroups); err != nil {
+			err = fmt.Errorf("无法处理 user 参数，请检查: %s", err.Error())
+			return
+		}
+	}
+
+	// 检查 opts.RLimits
+	if opts.helper.RLimits, err = resolveRLimits(opts.RLimits); err != nil {
+		err = fmt.Errorf("无法处理 rlimits 参数，请检查: %s", err.Error())
+		return
+	}
+
+	// 检查 opts.Capabilities, opts.AmbientCapabilities 和 opts.NoNewPrivs
+	if opts.helper.Capabilities, err = resolveCapabilities(opts); err != nil {
+		err = fmt.Errorf("无法处理 capabilities 参数，请检查: %s", err.Error())
+		return
+	}
+
+	// 检查 opts.ReadOnlyPaths 和 opts.ReadWritePaths
+	if len(opts.ReadOnlyPaths) > 0 || len(opts.ReadWritePaths) > 0 {
+		if abi := landlockABI(); abi == 0 {
+			logger.Errorf("内核不支持 Landlock，忽略 read_only_paths 和 read_write_paths 参数")
+		} else if opts.helper.Landlock, err = resolveLandlock(abi, opts.ReadOnlyPaths, opts.ReadWritePaths); err != nil {
+			err = fmt.Errorf("无法处理 read_only_paths, read_write_paths 参数，请检查: %s", err.Error())
+			return
+		}
+	}
+
+	// 检查 cgroup 参数
+	var cgSettings []cgroupSetting
+	if cgSettings, err = opts.cgroupSettings(); err != nil {
+		return
+	}
+	var cg *unitCgroup
+	if len(cgSettings) > 0 {
+		if cg, err = createUnitCgroup(opts.unitName, cgSettings); err != nil {
+			err = fmt.Errorf("无法创建 cgroup: %s", err.Error())
+			return
+		}
+	}
+	if cg != nil {
+		opts.helper.Cgroup = cg.dir
+		defer cg.remove()
+	}
+
+	// 检查调度参数
+	var sched schedOptions
+	if sched, err = opts.schedSettings(); err != nil {
+		return
+	}
+
+	// 构建环境变量
+	var env *environment
+	if env, err = buildEnvironment(opts, cred); err != nil {
+		err = fmt.Errorf("无法处理环境变量，请检查 env, env_file 参数: %s", err.Error())
+		return
+	}
+
 	// 构建 argv
 	if opts.Shell != "" {
 		if argv, err = shellquote.Split(opts.Shell); err != nil {
@@ -80,25 +353,125 @@ func execute(opts ExecuteOptions, logger *mlog.Logger) (err error) {
 		}
 	} else {
 		for _, arg := range opts.Command {
-			argv = append(argv, os.ExpandEnv(arg))
+			argv = append(argv, env.Expand(arg))
 		}
 	}
 
 	// 构建 cmd
-	var outPipe, errPipe io.Reader
 	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = opts.Dir
+	cmd.Env = env.Strings()
+	cmd.ExtraFiles = opts.extraFiles
+
+	// 标准输入，shell 模式下未设置 stdin 时，通过标准输入传递命令，否则通过额外的文件描述符传递
+	var stdinCleanup func()
+	if cmd.Stdin, stdinCleanup, err = opts.Stdin.open(opts.Dir, env); err != nil {
+		err = fmt.Errorf("无法处理 stdin 参数，请检查: %s", err.Error())
+		return
+	}
+	defer stdinCleanup()
+	// 其他单元通过 unit:<name> 将输出连接到此单元的标准输入
+	stdinSet := opts.Stdin.Mode != ""
+	if p := unitPipes[opts.unitName]; p != nil {
+		cmd.Stdin = p.r
+		stdinSet = true
+	}
 	if opts.Shell != "" {
-		cmd.Stdin = strings.NewReader(strings.Join(opts.Command, "\n"))
+		script := strings.Join(opts.Command, "\n")
+		if !stdinSet {
+			cmd.Stdin = strings.NewReader(script)
+		} else {
+			var scriptFile *os.File
+			if scriptFile, err = openScript(script, cred); err != nil {
+				err = fmt.Errorf("无法传递 shell 命令: %s", err.Error())
+				return
+			}
+			defer scriptFile.Close()
+			// 位于套接字激活传递的文件之后，不影响 LISTEN_FDS
+			cmd.ExtraFiles = append(append([]*os.File{}, opts.extraFiles...), scriptFile)
+			cmd.Args = append(cmd.Args, fmt.Sprintf("/dev/fd/%d", 2+len(cmd.ExtraFiles)))
+		}
 	}
-	cmd.Dir = opts.Dir
+
 	// 阻止信号传递
 	setupCmdSysProcAttr(cmd)
+	// 用户和组，使用辅助进程时，由辅助进程完成其他设置后再切换
+	if cred != nil {
+		if opts.helper.needed() {
+			opts.helper.Credential = cred
+		} else if err = setupCmdCredential(cmd, cred); err != nil {
+			return
+		}
+	}
+	// 辅助进程
+	if opts.helper.needed() {
+		if err = setupHelper(cmd, opts.helper); err != nil {
+			err = fmt.Errorf("无法使用辅助进程: %s", err.Error())
+			return
+		}
+	}
 
-	if outPipe, err = cmd.StdoutPipe(); err != nil {
-		return
+	// 标准输出和标准错误，使用伪终端时均为伪终端
+	var stdout, stderr output
+	defer stdout.close()
+	defer stderr.close()
+	if opts.TTY {
+		rows, cols := opts.TTYRows, opts.TTYCols
+		if rows == 0 {
+			rows = DefaultTTYRows
+		}
+		if cols == 0 {
+			cols = DefaultTTYCols
+		}
+		if rows < 0 || rows > 0xffff || cols < 0 || cols > 0xffff {
+			err = fmt.Errorf("无效的 tty_rows 或者 tty_cols: %dx%d", rows, cols)
+			return
+		}
+		var ptyMaster, ptySlave *os.File
+		if ptyMaster, ptySlave, err = openPTY(rows, cols); err != nil {
+			err = fmt.Errorf("无法分配伪终端: %s", err.Error())
+			return
+		}
+		// 进程及其子进程全部退出后，读取主设备会返回错误
+		stdout = output{w: ptySlave, r: ptyMaster, owned: true}
+		stderr = output{w: ptySlave}
+
+		// 未设置 stdin 时，标准输入同样为伪终端，shell 模式下标准输入用于传递命令
+		if opts.Shell == "" && !stdinSet {
+			cmd.Stdin = ptySlave
+		}
+		setupCmdTTY(cmd, 1)
+	} else {
+		var outTarget, errTarget outputTarget
+		if outTarget, err = parseOutputTarget(opts.Stdout); err != nil {
+			return
+		}
+		if errTarget, err = parseOutputTarget(opts.Stderr); err != nil {
+			return
+		}
+		if stdout, err = outTarget.open(opts.Dir, env); err != nil {
+			err = fmt.Errorf("无法处理 stdout 参数，请检查: %s", err.Error())
+			return
+		}
+		if stderr, err = errTarget.open(opts.Dir, env); err != nil {
+			err = fmt.Errorf("无法处理 stderr 参数，请检查: %s", err.Error())
+			return
+		}
 	}
-	if errPipe, err = cmd.StderrPipe(); err != nil {
-		return
+	if stdout.w != nil {
+		cmd.Stdout = stdout.w
+	}
+	if stderr.w != nil {
+		cmd.Stderr = stderr.w
+	}
+
+	// 记录到日志的输出
+	var outPipe, errPipe io.Reader
+	if stdout.r != nil {
+		outPipe = stdout.r
+	}
+	if stderr.r != nil {
+		errPipe = stderr.r
 	}
 
 	// charset
@@ -107,33 +480,118 @@ func execute(opts ExecuteOptions, logger *mlog.Logger) (err error) {
 		if enc == nil {
 			logger.Error("未知字符集: " + opts.Charset)
 		} else {
-			outPipe = enc.NewDecoder().Reader(outPipe)
-			errPipe = enc.NewDecoder().Reader(errPipe)
+			if outPipe != nil {
+				outPipe = enc.NewDecoder().Reader(outPipe)
+			}
+			if errPipe != nil {
+				errPipe = enc.NewDecoder().Reader(errPipe)
+			}
 		}
 	}
 
-	// 执行
-	if err = cmd.Start(); err != nil {
+	// 执行并记录 Pid
+	child := &childProcess{
+		group:    opts.KillMode != KillModeLeader,
+		forwards: forwards,
+		logger:   logger,
+	}
+	var cgBefore cgroupStat
+	if cg != nil {
+		cgBefore = cg.stat()
+	}
+	if err = startChild(cmd, child); err != nil {
 		return
 	}
+	// 关闭 minit 持有的写入端，进程及其子进程全部退出后，读取端才会结束
+	stdout.closeWriter()
+	stderr.closeWriter()
+
+	// 调度参数
+	if !sched.empty() {
+		for _, err1 := range applySched(child.pid, sched) {
+			logger.Errorf("%s", err1.Error())
+		}
+	}
 
-	// 记录 Pid
-	addPid(cmd.Process.Pid)
+	if opts.onStart != nil {
+		opts.onStart(child.pid)
+	}
+
+	// 进程运行期间检查 OOM 事件，避免子进程被 OOM 终止后直到主进程退出才记录
+	var cgSeen chan cgroupStat
+	cgDone := make(chan struct{})
+	if cg != nil {
+		cgSeen = cg.watchEvents(cgBefore, cgroupEventsInterval, cgDone, logger)
+	}
 
 	// 串流
-	go logger.StreamOut(outPipe)
-	go logger.StreamErr(errPipe)
+	var streams sync.WaitGroup
+	if outPipe != nil {
+		streams.Add(1)
+		go func() {
+			logger.StreamOut(outPipe)
+			streams.Done()
+		}()
+	}
+	if errPipe != nil {
+		streams.Add(1)
+		go func() {
+			logger.StreamErr(errPipe)
+			streams.Done()
+		}()
+	}
 
-	// 等待退出
-	if err = cmd.Wait(); err != nil {
+	// 等待退出，ctx 结束时停止进程
+	chErr := make(chan error, 1)
+	go func() {
+		chErr <- cmd.Wait()
+	}()
+	select {
+	case err = <-chErr:
+	case <-ctx.Done():
+		err = stopProcess(child, stopSignal, stopTimeout, chErr, logger)
+	}
+	// 读取剩余的输出，后台进程仍然持有输出时不再等待
+	chStreams := make(chan struct{})
+	go func() {
+		streams.Wait()
+		close(chStreams)
+	}()
+	select {
+	case <-chStreams:
+	case <-time.After(outputDrainTimeout):
+	}
+	if err != nil {
 		logger.Errorf("进程退出: %s", err.Error())
-		err = nil
 	} else {
 		logger.Printf("进程退出")
 	}
 
 	// 移除 Pid
-	removePid(cmd.Process.Pid)
+	removeChild(child)
 
+	// 资源统计
+	if cg != nil {
+		close(cgDone)
+		cg.report(<-cgSeen, logger)
+	}
+
+	return
+}
+
+// exitCode 从 execute 返回的错误中提取退出码，进程被信号终止时返回 128+信号值，ok 为 false 表示不是进程退出错误
+func exitCode(err error) (code int, ok bool) {
+	if err == nil {
+		return 0, true
+	}
+	var ee *exec.ExitError
+	if ee, ok = err.(*exec.ExitError); !ok {
+		return
+	}
+	if status, found := ee.Sys().(syscall.WaitStatus); found && status.Signaled() {
+		code = 128 + int(status.Signal())
+		return
+	}
+	code = ee.ExitCode()
 	return
 }
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/acicn/minit/pkg/mlog"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func newTestLogger(t *testing.T, dir string) *mlog.Logger {
+	logger, err := mlog.NewLogger(mlog.LoggerOptions{Dir: dir, Name: "test", Filename: "test"})
+	require.NoError(t, err)
+	return logger
+}
+
+func TestParseSignal(t *testing.T) {
+	sig, err := parseSignal("SIGQUIT")
+	require.NoError(t, err)
+	require.Equal(t, syscall.SIGQUIT, sig)
+	sig, err = parseSignal(" term ")
+	require.NoError(t, err)
+	require.Equal(t, syscall.SIGTERM, sig)
+	sig, err = parseSignal("9")
+	require.NoError(t, err)
+	require.Equal(t, syscall.SIGKILL, sig)
+	_, err = parseSignal("SIGNOPE")
+	require.Error(t, err)
+}
+
+func TestParseForwardSignals(t *testing.T) {
+	forwards, err := parseForwardSignals([]string{"SIGHUP", "SIGQUIT:SIGINT"})
+	require.NoError(t, err)
+	require.Equal(t, map[syscall.Signal]syscall.Signal{
+		syscall.SIGHUP:  syscall.SIGHUP,
+		syscall.SIGQUIT: syscall.SIGINT,
+	}, forwards)
+	_, err = parseForwardSignals([]string{"SIGTERM"})
+	require.Error(t, err)
+	for _, name := range []string{"SIGINT", "SIGKILL", "SIGSTOP", "SIGURG", "SIGCHLD"} {
+		_, err = parseForwardSignals([]string{name})
+		require.Error(t, err, name)
+	}
+	_, err = parseForwardSignals([]string{"SIGURG:SIGHUP"})
+	require.Error(t, err)
+	_, err = parseForwardSignals([]string{"SIGHUP:SIGINT:SIGQUIT"})
+	require.Error(t, err)
+}
+
+func TestExecuteShellStdinWithUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("需要 root 权限")
+	}
+	dir, err := ioutil.TempDir("", "minit-execute")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.Chmod(dir, 0755))
+	logger := newTestLogger(t, dir)
+	defer logger.Close()
+
+	// 设置了 stdin 时，命令通过 /dev/fd/N 传递，切换用户后仍然需要能够读取
+	err = execute(context.Background(), ExecuteOptions{
+		Dir:     dir,
+		Shell:   "/bin/sh",
+		Command: []string{"read line", "echo \"$line $(id -u)\""},
+		Stdin:   StdinOptions{Mode: StdinData, Data: "hello\n"},
+		Stdout:  "file:out",
+		User:    "65534",
+	}, logger)
+	require.NoError(t, err)
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "out"))
+	require.NoError(t, err)
+	require.Equal(t, "hello 65534\n", string(buf))
+}
+
+func TestExecuteStopTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minit-execute")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logger := newTestLogger(t, dir)
+	defer logger.Close()
+
+	// 进程忽略 SIGTERM，超时后被 SIGKILL 终止
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*300, cancel)
+	startedAt := time.Now()
+	err = execute(ctx, ExecuteOptions{
+		Command:     []string{"sh", "-c", `trap "" TERM; sleep 60`},
+		StopTimeout: time.Millisecond * 500,
+	}, logger)
+	elapsed := time.Since(startedAt)
+	code, ok := exitCode(err)
+	require.True(t, ok)
+	require.Equal(t, 128+int(syscall.SIGKILL), code)
+	require.True(t, elapsed >= time.Millisecond*800 && elapsed < time.Millisecond*1500, elapsed.String())
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "test.err.log"))
+	require.NoError(t, err)
+	require.Contains(t, string(buf), "进程在 500ms 内未退出，发送 SIGKILL")
+
+	// 使用 stop_signal 指定的信号停止进程
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*300, cancel)
+	startedAt = time.Now()
+	err = execute(ctx, ExecuteOptions{
+		Command:     []string{"sh", "-c", `trap "exit 3" INT; sleep 60`},
+		StopSignal:  "SIGINT",
+		StopTimeout: time.Second * 5,
+	}, logger)
+	elapsed = time.Since(startedAt)
+	code, ok = exitCode(err)
+	require.True(t, ok)
+	require.Equal(t, 3, code)
+	require.True(t, elapsed < time.Second*2, elapsed.String())
+}
+
+func TestExecuteKillMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minit-execute")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logger := newTestLogger(t, dir)
+	defer logger.Close()
+
+	run := func(killMode string) (pid int) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(time.Millisecond*300, cancel)
+		_ = execute(ctx, ExecuteOptions{
+			Command:     []string{"sh", "-c", "sleep 60 & wait"},
+			KillMode:    killMode,
+			StopTimeout: time.Second * 5,
+			onStart: func(p int) {
+				pid = p
+			},
+		}, logger)
+		return
+	}
+
+	// group 模式下，后台进程同样收到停止信号
+	pid := run(KillModeGroup)
+	require.NotZero(t, pid)
+	require.Empty(t, findProcessGroupMembers(pid))
+
+	// leader 模式下，后台进程继续运行，并记录到日志
+	pid = run(KillModeLeader)
+	require.NotZero(t, pid)
+	members := findProcessGroupMembers(pid)
+	defer syscall.Kill(-pid, syscall.SIGKILL)
+	require.Len(t, members, 1)
+	require.Equal(t, "sleep", members[0].Comm)
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "test.err.log"))
+	require.NoError(t, err)
+	require.Contains(t, string(buf), fmt.Sprintf("进程组 %d 中仍有存活进程: %d(sleep)", pid, members[0].Pid))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// unitLevel 返回单元的运行级别，未知类型返回 0
+func unitLevel(unit Unit) RunnerLevel {
+	if fac := RunnerFactories[unit.Kind]; fac != nil {
+		return fac.Level
+	}
+	return 0
+}
+
+// resolveUnitDependencies 解析 after 和 requires 字段，返回按照依赖关系排序后的单元，以及每个单元需要等待的同级单元
+func resolveUnitDependencies(units []Unit) (sorted []Unit, deps map[string][]string, err error) {
+	byName := map[string]Unit{}
+	byGroup := map[string][]string{}
+	for _, unit := range units {
+		byName[unit.Name] = unit
+		byGroup[unit.Group] = append(byGroup[unit.Group], unit.Name)
+	}
+
+	// 展开依赖关系
+	all := map[string][]string{}
+	deps = map[string][]string{}
+	for _, unit := range units {
+		found := map[string]bool{}
+		for _, item := range []struct {
+			field    string
+			refs     []string
+			required bool
+		}{
+			{field: "after", refs: unit.After},
+			{field: "requires", refs: unit.Requires, required: true},
+		} {
+			for _, ref := range item.refs {
+				ref = strings.TrimSpace(ref)
+				var names []string
+				if strings.HasPrefix(ref, "@") {
+					for _, name := range byGroup[strings.TrimPrefix(ref, "@")] {
+						if name != unit.Name {
+							names = append(names, name)
+						}
+					}
+				} else if _, ok := byName[ref]; ok {
+					names = append(names, ref)
+				}
+				if len(names) == 0 {
+					if item.required {
+						err = fmt.Errorf("单元 %s 依赖的 %s 不存在或者未启用，检查 %s 字段", unit.Name, ref, item.field)
+						return
+					}
+					continue
+				}
+				for _, name := range names {
+					if found[name] {
+						continue
+					}
+					found[name] = true
+					dep := byName[name]
+					if unitLevel(dep) > unitLevel(unit) {
+						err = fmt.Errorf("单元 %s 不能依赖启动顺序更靠后的单元 %s，检查 %s 字段", unit.Name, dep.Name, item.field)
+						return
+					}
+					all[unit.Name] = append(all[unit.Name], name)
+					// 更低级别的单元总是先于当前单元完成启动，无需等待
+					if unitLevel(dep) == unitLevel(unit) {
+						deps[unit.Name] = append(deps[unit.Name], name)
+					}
+				}
+			}
+		}
+	}
+
+	// 深度优先遍历，检查循环依赖并排序
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	states := map[string]int{}
+	var stack []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch states[name] {
+		case visiting:
+			for i, item := range stack {
+				if item == name {
+					return fmt.Errorf("单元依赖关系存在循环: %s", strings.Join(append(stack[i:], name), " -> "))
+				}
+			}
+		case visited:
+			return nil
+		}
+		states[name] = visiting
+		stack = append(stack, name)
+		for _, dep := range all[name] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		states[name] = visited
+		sorted = append(sorted, byName[name])
+		return nil
+	}
+	for _, unit := range units {
+		if err = visit(unit.Name); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// shutdownTiers 按照依赖关系逆序计算关闭顺序，依赖其他单元的单元先于被依赖的单元关闭，同一批次内的单元同时关闭
+func shutdownTiers(names []string, deps map[string][]string) (tiers [][]string) {
+	depths := map[string]int{}
+	var depth func(name string) int
+	depth = func(name string) int {
+		if d, ok := depths[name]; ok {
+			return d
+		}
+		var d int
+		for _, dep := range deps[name] {
+			if dd := depth(dep) + 1; dd > d {
+				d = dd
+			}
+		}
+		depths[name] = d
+		return d
+	}
+
+	var max int
+	for _, name := range names {
+		if d := depth(name); d > max {
+			max = d
+		}
+	}
+	for d := max; d >= 0; d-- {
+		var tier []string
+		for _, name := range names {
+			if depths[name] == d {
+				tier = append(tier, name)
+			}
+		}
+		if len(tier) > 0 {
+			tiers = append(tiers, tier)
+		}
+	}
+	return
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func unitNames(units []Unit) (names []string) {
+	for _, unit := range units {
+		names = append(names, unit.Name)
+	}
+	return
+}
+
+func TestResolveUnitDependencies(t *testing.T) {
+	units := []Unit{
+		{Name: "app", Kind: KindDaemon, Group: DefaultGroup, Requires: []string{"redis"}, After: []string{"@cache", "missing"}},
+		{Name: "redis", Kind: KindDaemon, Group: DefaultGroup},
+		{Name: "memcached", Kind: KindDaemon, Group: "cache"},
+		{Name: "migrate", Kind: KindOnce, Group: DefaultGroup},
+		{Name: "web", Kind: KindDaemon, Group: DefaultGroup, After: []string{"app", "migrate"}},
+	}
+	sorted, deps, err := resolveUnitDependencies(units)
+	require.NoError(t, err)
+	require.Equal(t, []string{"memcached", "redis", "app", "migrate", "web"}, unitNames(sorted))
+	require.Equal(t, []string{"memcached", "redis"}, deps["app"])
+	require.Equal(t, []string{"app"}, deps["web"])
+	require.Empty(t, deps["redis"])
+}
+
+func TestResolveUnitDependenciesErrors(t *testing.T) {
+	_, _, err := resolveUnitDependencies([]Unit{
+		{Name: "app", Kind: KindDaemon, Requires: []string{"redis"}},
+	})
+	require.Error(t, err)
+
+	_, _, err = resolveUnitDependencies([]Unit{
+		{Name: "migrate", Kind: KindOnce, After: []string{"redis"}},
+		{Name: "redis", Kind: KindDaemon},
+	})
+	require.Error(t, err)
+
+	_, _, err = resolveUnitDependencies([]Unit{
+		{Name: "aa", Kind: KindDaemon, After: []string{"bb"}},
+		{Name: "bb", Kind: KindDaemon, After: []string{"cc"}},
+		{Name: "cc", Kind: KindDaemon, Requires: []string{"aa"}},
+	})
+	require.EqualError(t, err, "单元依赖关系存在循环: aa -> bb -> cc -> aa")
+}
+
+func TestShutdownTiers(t *testing.T) {
+	names := []string{"redis", "app", "web", "worker", "cron"}
+	deps := map[string][]string{
+		"app":    {"redis"},
+		"web":    {"app"},
+		"worker": {"redis"},
+	}
+	require.Equal(t, [][]string{{"web"}, {"app", "worker"}, {"redis", "cron"}}, shutdownTiers(names, deps))
+	require.Equal(t, [][]string{{"redis", "cron"}}, shutdownTiers([]string{"redis", "cron"}, nil))
+	require.Empty(t, shutdownTiers(nil, nil))
+}
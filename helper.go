@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// helperEnvKey 辅助进程的参数通过此环境变量传递
+const helperEnvKey = "MINIT_HELPER"
+
+// helperOptions 部分设置无法通过 exec.Cmd 完成，minit 会重新执行自身作为辅助进程，完成设置后再 exec 目标命令，进程号保持不变
+type helperOptions struct {
+	Path string   `json:"path"`
+	Args []string `json:"args"`
+
+	ListenPID bool           `json:"listen_pid,omitempty"` // 设置 LISTEN_PID 环境变量为当前进程号
+	RLimits   []helperRLimit `json:"rlimits,omitempty"`    // 资源限制
+	Cgroup    string         `json:"cgroup,omitempty"`     // 加入的 cgroup 目录
+
+	Capabilities helperCapabilities `json:"capabilities"`       // 能力和 no_new_privs
+	Landlock     *helperLandlock    `json:"landlock,omitempty"` // 文件系统访问限制
+
+	Credential *credential `json:"credential,omitempty"` // 用户和组，使用辅助进程时，在完成其他设置后再切换
+}
+
+// helperRLimit 由辅助进程设置的资源限制
+type helperRLimit struct {
+	Name     string `json:"name"`
+	Resource int    `json:"resource"`
+	Cur      uint64 `json:"cur"`
+	Max      uint64 `json:"max"`
+}
+
+// helperCapabilities 由辅助进程设置的能力
+type helperCapabilities struct {
+	Bounding   *[]int `json:"bounding,omitempty"` // 保留在 bounding set 中的能力，nil 表示不修改
+	Ambient    []int  `json:"ambient,omitempty"`  // 切换用户后仍然保留的 ambient 能力
+	NoNewPrivs bool   `json:"no_new_privs,omitempty"`
+}
+
+func (c helperCapabilities) empty() bool {
+	return c.Bounding == nil && len(c.Ambient) == 0 && !c.NoNewPrivs
+}
+
+// helperLandlock 由辅助进程设置的 Landlock 规则集
+type helperLandlock struct {
+	Handled uint64               `json:"handled"` // 受限制的操作，未被规则允许时禁止
+	Rules   []helperLandlockRule `json:"rules"`
+}
+
+// helperLandlockRule 允许对指定路径及其子路径执行的操作
+type helperLandlockRule struct {
+	Path   string `json:"path"`
+	Access uint64 `json:"access"`
+}
+
+// needed 是否需要使用辅助进程
+func (o helperOptions) needed() bool {
+	return o.ListenPID || len(o.RLimits) > 0 || o.Cgroup != "" || !o.Capabilities.empty() || o.Landlock != nil
+}
+
+// setupHelper 将 cmd 改为通过辅助进程执行
+func setupHelper(cmd *exec.Cmd, opts helperOptions) (err error) {
+	opts.Path = cmd.Path
+	opts.Args = cmd.Args
+
+	var buf []byte
+	if buf, err = json.Marshal(opts); err != nil {
+		return
+	}
+	var exe string
+	if exe, err = helperExecutable(); err != nil {
+		return
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, helperEnvKey+"="+string(buf))
+	cmd.Path = exe
+	return
+}
+
+// isHelper 当前进程是否为辅助进程
+func isHelper() bool {
+	return os.Getenv(helperEnvKey) != ""
+}
+
+// runHelper 作为辅助进程运行，成功时不会返回，失败时以 127 退出
+func runHelper() {
+	err := execHelper()
+	_, _ = fmt.Fprintf(os.Stderr, "minit 辅助进程执行失败: %s\n", err.Error())
+	os.Exit(127)
+}
+
+func execHelper() (err error) {
+	var opts helperOptions
+	if err = json.Unmarshal([]byte(os.Getenv(helperEnvKey)), &opts); err != nil {
+		return
+	}
+	if err = setupHelperProcess(opts); err != nil {
+		return
+	}
+
+	env := make([]string, 0)
+	for _, item := range os.Environ() {
+		if strings.HasPrefix(item, helperEnvKey+"=") {
+			continue
+		}
+		env = append(env, item)
+	}
+	if opts.ListenPID {
+		env = append(env, "LISTEN_PID="+strconv.Itoa(os.Getpid()))
+	}
+
+	return syscall.Exec(opts.Path, opts.Args, env)
+}
@@ -0,0 +1,79 @@
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+)
+
+func init() {
+	// 辅助进程只在当前线程上切换用户和组，exec 后新进程使用当前线程的身份，需要锁定主线程
+	if isHelper() {
+		runtime.LockOSThread()
+	}
+}
+
+// setupHelperProcess 在辅助进程中，完成 exec 之前的设置
+func setupHelperProcess(opts helperOptions) (err error) {
+	// 在 exec 之前加入 cgroup，目标命令创建的所有进程都会受到限制
+	if opts.Cgroup != "" {
+		if err = ioutil.WriteFile(filepath.Join(opts.Cgroup, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+			err = fmt.Errorf("无法加入 cgroup %s: %s", opts.Cgroup, err.Error())
+			return
+		}
+	}
+
+	// 资源限制，需要在切换用户前设置，以便提高硬限制
+	for _, item := range opts.RLimits {
+		limit := syscall.Rlimit{Cur: item.Cur, Max: item.Max}
+		if err = syscall.Setrlimit(item.Resource, &limit); err != nil {
+			err = fmt.Errorf("无法设置 RLIMIT_%s=%s:%s: %s", item.Name, formatRLimitValue(item.Cur), formatRLimitValue(item.Max), err.Error())
+			return
+		}
+	}
+
+	// 文件系统访问限制，在切换用户前打开路径，避免用户没有权限访问上级目录
+	if opts.Landlock != nil {
+		if err = applyLandlock(opts.Landlock); err != nil {
+			return
+		}
+	}
+
+	// 能力，移除 bounding set 需要 CAP_SETPCAP，必须在切换用户前完成
+	if err = setupCapabilitiesBeforeCredential(opts.Capabilities); err != nil {
+		return
+	}
+
+	// 用户和组
+	if cred := opts.Credential; cred != nil {
+		groups := make([]int, 0, len(cred.Groups))
+		for _, gid := range cred.Groups {
+			groups = append(groups, int(gid))
+		}
+		if err = unix.Setgroups(groups); err != nil {
+			err = fmt.Errorf("无法设置附加组: %s", err.Error())
+			return
+		}
+		if err = unix.Setresgid(int(cred.Gid), int(cred.Gid), int(cred.Gid)); err != nil {
+			err = fmt.Errorf("无法切换组 %d: %s", cred.Gid, err.Error())
+			return
+		}
+		if err = unix.Setresuid(int(cred.Uid), int(cred.Uid), int(cred.Uid)); err != nil {
+			err = fmt.Errorf("无法切换用户 %d: %s", cred.Uid, err.Error())
+			return
+		}
+	}
+
+	// ambient 能力和 no_new_privs
+	if err = setupCapabilitiesAfterCredential(opts.Capabilities); err != nil {
+		return
+	}
+	return
+}
@@ -0,0 +1,157 @@
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// golang.org/x/sys/unix 尚未包含 Landlock，直接使用系统调用，各个架构的编号相同
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1 << 0
+	landlockRulePathBeneath      = 1
+)
+
+const (
+	landlockAccessFSExecute    = 1 << 0
+	landlockAccessFSWriteFile  = 1 << 1
+	landlockAccessFSReadFile   = 1 << 2
+	landlockAccessFSReadDir    = 1 << 3
+	landlockAccessFSRemoveDir  = 1 << 4
+	landlockAccessFSRemoveFile = 1 << 5
+	landlockAccessFSMakeChar   = 1 << 6
+	landlockAccessFSMakeDir    = 1 << 7
+	landlockAccessFSMakeReg    = 1 << 8
+	landlockAccessFSMakeSock   = 1 << 9
+	landlockAccessFSMakeFifo   = 1 << 10
+	landlockAccessFSMakeBlock  = 1 << 11
+	landlockAccessFSMakeSym    = 1 << 12
+	landlockAccessFSRefer      = 1 << 13 // ABI 2
+	landlockAccessFSTruncate   = 1 << 14 // ABI 3
+	landlockAccessFSIoctlDev   = 1 << 15 // ABI 5
+
+	// landlockAccessFSReadOnly 只读路径允许的操作
+	landlockAccessFSReadOnly = landlockAccessFSExecute | landlockAccessFSReadFile | landlockAccessFSReadDir
+	// landlockAccessFSFile 可以用于普通文件的操作，其余操作只能用于目录
+	landlockAccessFSFile = landlockAccessFSExecute | landlockAccessFSWriteFile | landlockAccessFSReadFile |
+		landlockAccessFSTruncate | landlockAccessFSIoctlDev
+)
+
+var (
+	landlockABIOnce  sync.Once
+	landlockABIValue int
+)
+
+// landlockABI 返回内核支持的 Landlock ABI 版本，0 表示不可用
+func landlockABI() int {
+	landlockABIOnce.Do(func() {
+		abi, _, errno := unix.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+		if errno == 0 {
+			landlockABIValue = int(abi)
+		}
+	})
+	return landlockABIValue
+}
+
+// landlockHandledAccessFS 返回指定 ABI 版本支持的全部文件系统操作，未被规则允许的操作都会被禁止
+func landlockHandledAccessFS(abi int) (access uint64) {
+	access = landlockAccessFSMakeSym<<1 - 1
+	if abi >= 2 {
+		access |= landlockAccessFSRefer
+	}
+	if abi >= 3 {
+		access |= landlockAccessFSTruncate
+	}
+	if abi >= 5 {
+		access |= landlockAccessFSIoctlDev
+	}
+	return
+}
+
+// resolveLandlock 检查 read_only_paths 和 read_write_paths 字段，路径以 - 开头表示不存在时忽略
+func resolveLandlock(abi int, readOnly []string, readWrite []string) (l *helperLandlock, err error) {
+	handled := landlockHandledAccessFS(abi)
+	l = &helperLandlock{Handled: handled}
+	add := func(paths []string, access uint64) (err error) {
+		for _, path := range paths {
+			path = strings.TrimSpace(path)
+			optional := strings.HasPrefix(path, "-")
+			path = strings.TrimPrefix(path, "-")
+			if !filepath.IsAbs(path) {
+				err = fmt.Errorf("路径必须为绝对路径: %s", path)
+				return
+			}
+			var info os.FileInfo
+			if info, err = os.Stat(path); err != nil {
+				if optional && os.IsNotExist(err) {
+					err = nil
+					continue
+				}
+				return
+			}
+			rule := helperLandlockRule{Path: filepath.Clean(path), Access: access & handled}
+			if !info.IsDir() {
+				rule.Access &= landlockAccessFSFile
+			}
+			l.Rules = append(l.Rules, rule)
+		}
+		return
+	}
+	if err = add(readOnly, landlockAccessFSReadOnly); err != nil {
+		return
+	}
+	if err = add(readWrite, handled); err != nil {
+		return
+	}
+	return
+}
+
+// applyLandlock 在辅助进程中创建 Landlock 规则集并限制当前进程，限制会被 exec 后的进程及其子进程继承
+func applyLandlock(l *helperLandlock) (err error) {
+	attr := struct{ HandledAccessFS uint64 }{HandledAccessFS: l.Handled}
+	fd, _, errno := unix.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		err = fmt.Errorf("无法创建 Landlock 规则集: %s", errno.Error())
+		return
+	}
+	defer unix.Close(int(fd))
+
+	for _, rule := range l.Rules {
+		var pfd int
+		if pfd, err = unix.Open(rule.Path, unix.O_PATH|unix.O_CLOEXEC, 0); err != nil {
+			err = fmt.Errorf("无法打开 %s: %s", rule.Path, err.Error())
+			return
+		}
+		// struct landlock_path_beneath_attr 为 packed 结构体，共 12 字节
+		var buf [12]byte
+		*(*uint64)(unsafe.Pointer(&buf[0])) = rule.Access
+		*(*int32)(unsafe.Pointer(&buf[8])) = int32(pfd)
+		_, _, errno = unix.Syscall6(sysLandlockAddRule, fd, landlockRulePathBeneath, uintptr(unsafe.Pointer(&buf[0])), 0, 0, 0)
+		_ = unix.Close(pfd)
+		if errno != 0 {
+			err = fmt.Errorf("无法添加 Landlock 规则 %s: %s", rule.Path, errno.Error())
+			return
+		}
+	}
+
+	// 非特权进程使用 Landlock 必须设置 no_new_privs
+	if err = unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		err = fmt.Errorf("无法设置 no_new_privs: %s", err.Error())
+		return
+	}
+	if _, _, errno = unix.Syscall(sysLandlockRestrictSelf, fd, 0, 0); errno != 0 {
+		err = fmt.Errorf("无法启用 Landlock: %s", errno.Error())
+		return
+	}
+	return
+}
@@ -0,0 +1,39 @@
+//+build linux
+
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLandlockHandledAccessFS(t *testing.T) {
+	require.Equal(t, uint64(0x1fff), landlockHandledAccessFS(1))
+	require.Equal(t, uint64(0x3fff), landlockHandledAccessFS(2))
+	require.Equal(t, uint64(0x7fff), landlockHandledAccessFS(4))
+	require.Equal(t, uint64(0xffff), landlockHandledAccessFS(5))
+}
+
+func TestResolveLandlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minit-landlock")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(file, []byte("test"), 0644))
+
+	l, err := resolveLandlock(3, []string{dir, "-" + filepath.Join(dir, "missing")}, []string{file})
+	require.NoError(t, err)
+	require.Equal(t, landlockHandledAccessFS(3), l.Handled)
+	require.Equal(t, []helperLandlockRule{
+		{Path: dir, Access: landlockAccessFSReadOnly},
+		{Path: file, Access: landlockAccessFSExecute | landlockAccessFSWriteFile | landlockAccessFSReadFile | landlockAccessFSTruncate},
+	}, l.Rules)
+
+	_, err = resolveLandlock(3, []string{filepath.Join(dir, "missing")}, nil)
+	require.Error(t, err)
+	_, err = resolveLandlock(3, []string{"relative"}, nil)
+	require.Error(t, err)
+}
@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type FilterMode int
@@ -56,6 +57,20 @@ type Unit struct {
 	Group string `yaml:"group"` // 单元分组
 	Kind  string `yaml:"kind"`  // 单元类型
 	Count int    `yaml:"count"` // 单元副本数量
+	Main  bool   `yaml:"main"`  // daemon 单元，主单元退出后，minit 关闭其他单元，并使用主单元的退出码退出
+
+	Critical bool `yaml:"critical"` // once, daemon, cron 单元，关键单元失败后，minit 关闭所有单元并以非零退出码退出
+
+	After    []string `yaml:"after"`    // 在指定的单元启动之后启动，可以使用 @group 指定一组单元
+	Requires []string `yaml:"requires"` // 同 after，但是指定的单元必须存在
+
+	Readiness *Probe `yaml:"readiness"` // daemon 单元，就绪检查，通过前单元处于 starting 状态，依赖此单元的单元不会启动
+	Liveness  *Probe `yaml:"liveness"`  // daemon 单元，存活检查，连续失败后停止进程，按照重启策略重启
+
+	Type     string        `yaml:"type"`     // daemon 单元，simple (默认) 或者 notify，notify 进程通过 sd_notify 发送 READY=1 后视为就绪
+	Watchdog time.Duration `yaml:"watchdog"` // daemon 单元，看门狗超时时间，进程需要通过 sd_notify 定期发送 WATCHDOG=1，超时后停止进程，按照重启策略重启
+
+	Sockets []string `yaml:"sockets"` // daemon 单元，启动前预先监听的套接字，比如 tcp://:8080，通过 LISTEN_FDS 传递给进程，重启期间保持监听
 
 	Raw bool `yaml:"raw"` // 不对渲染文件进行空白行处理
 
@@ -64,12 +79,70 @@ type Unit struct {
 	Cron string `yaml:"cron"` // cron 单元, 定时表达式
 	Mode string `yaml:"mode"` // logrotate 单元，模式 daily 或者 size
 	Keep int    `yaml:"keep"` // logrotate 单元，保留天数/份数
+
+	Restart          string `yaml:"restart"`            // daemon 单元，重启策略 always, on-failure 或者 never
+	SuccessExitCodes []int  `yaml:"success_exit_codes"` // 视为成功的退出码，0 始终视为成功
+
+	RestartDelay       time.Duration `yaml:"restart_delay"`        // daemon 单元，首次重启等待时间，默认 5s
+	RestartDelayMax    time.Duration `yaml:"restart_delay_max"`    // daemon 单元，重启等待时间上限，默认 1m
+	RestartMultiplier  float64       `yaml:"restart_multiplier"`   // daemon 单元，每次重启等待时间的增长倍数，默认 2
+	RestartJitter      float64       `yaml:"restart_jitter"`       // daemon 单元，重启等待时间的随机抖动比例，0 ~ 1
+	RestartReset       time.Duration `yaml:"restart_reset"`        // daemon 单元，进程稳定运行超过此时间后，重置重启等待时间，默认 1m
+	StartLimit         int           `yaml:"start_limit"`          // daemon 单元，时间窗口内最多重启次数，超过后单元进入 failed 状态
+	StartLimitInterval time.Duration `yaml:"start_limit_interval"` // daemon 单元，start_limit 的时间窗口，默认 10m
+
+	StartDelay     time.Duration `yaml:"start_delay"`     // daemon 单元，首次启动前的等待时间
+	ReplicaStagger time.Duration `yaml:"replica_stagger"` // daemon 单元，使用 count 创建的副本，第 i 个副本启动和重启时额外等待 i 倍此时间
+
+	replica int // 使用 count 创建的副本序号，从 0 开始
+}
+
+// UnmarshalYAML yaml.v2 遇到 null 时会将字段保持为零值，单独解析 stdin, stdout 和 stderr 字段，以区分未设置和显式设置为 null
+func (u *Unit) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
+	type plainUnit Unit
+	if err = unmarshal((*plainUnit)(u)); err != nil {
+		return
+	}
+	var raw map[string]interface{}
+	if err = unmarshal(&raw); err != nil {
+		return
+	}
+	if val, ok := raw["stdin"]; ok {
+		if u.Stdin, err = parseStdin(val); err != nil {
+			return
+		}
+	}
+	// 未加引号的 null 解析为空字符串，会被当作默认值 log
+	if val, ok := raw["stdout"]; ok && val == nil {
+		u.Stdout = OutputNull
+	}
+	if val, ok := raw["stderr"]; ok && val == nil {
+		u.Stderr = OutputNull
+	}
+	return
 }
 
 func (u Unit) CanonicalName() string {
 	return u.Kind + "/" + u.Name
 }
 
+// IsSuccess 根据 success_exit_codes 判断 execute 的返回值是否代表成功退出
+func (u Unit) IsSuccess(err error) bool {
+	code, ok := exitCode(err)
+	if !ok {
+		return false
+	}
+	if code == 0 {
+		return true
+	}
+	for _, c := range u.SuccessExitCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
 func LoadArgsMain() (unit Unit, ok bool, err error) {
 	args := flag.Args()
 	for i, arg := range args {
@@ -85,8 +158,10 @@ func LoadArgsMain() (unit Unit, ok bool, err error) {
 		Name:  "arg-main",
 		Group: DefaultGroup,
 		Kind:  KindDaemon,
+		Main:  optExitWithMain,
 		ExecuteOptions: ExecuteOptions{
 			Command: args,
+			Stdin:   StdinOptions{Mode: optMainStdin},
 		},
 	}
 	ok = true
@@ -119,10 +194,12 @@ func LoadEnvMain() (unit Unit, ok bool, err error) {
 		Name:  name,
 		Group: group,
 		Kind:  kind,
+		Main:  optExitWithMain,
 		ExecuteOptions: ExecuteOptions{
 			Command: command,
 			Dir:     strings.TrimSpace(os.Getenv("MINIT_MAIN_DIR")),
 			Charset: strings.TrimSpace(os.Getenv("MINIT_MAIN_CHARSET")),
+			Stdin:   StdinOptions{Mode: optMainStdin},
 		},
 	}
 	ok = true
@@ -171,6 +248,7 @@ func LoadFile(fn string) (units []Unit, err error) {
 		unit.Cron = strings.TrimSpace(unit.Cron)
 		unit.Dir = strings.TrimSpace(unit.Dir)
 		unit.Group = strings.TrimSpace(unit.Group)
+		unit.Restart = strings.TrimSpace(unit.Restart)
 
 		// 默认组名
 		if unit.Group == "" {
@@ -197,6 +275,7 @@ func LoadFile(fn string) (units []Unit, err error) {
 			for i := 0; i < unit.Count; i++ {
 				subUnit := unit
 				subUnit.Name = fmt.Sprintf("%s-%d", unit.Name, i+1)
+				subUnit.replica = i
 				units = append(units, subUnit)
 			}
 		} else {
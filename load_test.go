@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/require"
 	"path/filepath"
 	"testing"
@@ -11,4 +12,8 @@ func TestLoadDir(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "cron", units[4].Kind)
 	require.Equal(t, "@every 10s", units[4].Cron)
+	for i := 0; i < 3; i++ {
+		require.Equal(t, fmt.Sprintf("sleep-%d", i+1), units[1+i].Name)
+		require.Equal(t, i, units[1+i].replica)
+	}
 }
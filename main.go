@@ -8,15 +8,24 @@ import (
 	"os"
 	"os/signal"
 	"regexp"
-	"sync"
+	"strings"
 	"syscall"
 	"time"
 )
 
 var (
-	optUnitDir   string
-	optLogDir    string
-	optQuickExit bool
+	optUnitDir         string
+	optLogDir          string
+	optQuickExit       bool
+	optShutdownTimeout time.Duration
+	optExitWithMain    bool
+	optMainStdin       string
+)
+
+const (
+	// forceShutdownInterval 关闭过程中，距离开始关闭超过此时间后再次接收到信号，才强制结束；
+	// 同一个信号可能被投递多次，比如 timeout 命令会同时向子进程和进程组发送信号
+	forceShutdownInterval = time.Second
 )
 
 var (
@@ -27,29 +36,92 @@ var (
 	log *mlog.Logger
 )
 
-func exit(err *error) {
+// shutdownRequest 由单元发起的关闭请求
+type shutdownRequest struct {
+	name   string // 发起请求的单元
+	code   int    // minit 的退出码
+	reason string
+	failed bool // 是否因为关键单元失败而关闭
+}
+
+var (
+	chShutdown = make(chan shutdownRequest, 1)
+)
+
+// requestShutdown 请求关闭 minit，仅第一个请求生效
+func requestShutdown(req shutdownRequest) {
+	select {
+	case chShutdown <- req:
+	default:
+	}
+}
+
+// requestCriticalShutdown 关键单元失败，请求关闭 minit
+func requestCriticalShutdown(name string, reason string) {
+	requestShutdown(shutdownRequest{
+		name:   name,
+		code:   1,
+		reason: reason,
+		failed: true,
+	})
+}
+
+func exit(err *error, code *int) {
 	if *err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "%s [%s] 错误退出: %s\n", time.Now().Format(mlog.LoggerDateLayout), "minit", (*err).Error())
-		os.Exit(1)
+		if *code == 0 {
+			*code = 1
+		}
+		os.Exit(*code)
+	} else if *code != 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "%s [%s] 退出，退出码 %d\n", time.Now().Format(mlog.LoggerDateLayout), "minit", *code)
+		os.Exit(*code)
 	} else {
 		_, _ = fmt.Fprintf(os.Stdout, "%s [%s] 正常退出\n", time.Now().Format(mlog.LoggerDateLayout), "minit")
 	}
 }
 
 func main() {
-	var err error
-	defer exit(&err)
+	// 辅助进程
+	if isHelper() {
+		runHelper()
+	}
+
+	var (
+		err  error
+		code int
+	)
+	defer exit(&err, &code)
 
 	// 命令行参数
 	flag.StringVar(&optUnitDir, "unit-dir", "/etc/minit.d", "配置单元目录")
 	flag.StringVar(&optLogDir, "log-dir", "/var/log/minit", "日志目录")
 	flag.BoolVar(&optQuickExit, "quick-exit", false, "如果没有 L3 任务（守护进程，定时任务 等），则自动退出")
+	flag.DurationVar(&optShutdownTimeout, "shutdown-timeout", time.Second*25, "关闭时等待所有进程退出的最长时间，超时后强制结束所有进程")
+	flag.BoolVar(&optExitWithMain, "exit-with-main", false, "将命令行参数或者 MINIT_MAIN 创建的单元设置为主单元，主单元退出时 minit 随之退出")
+	flag.StringVar(&optMainStdin, "main-stdin", "", "命令行参数或者 MINIT_MAIN 创建的单元的标准输入，null 或者 inherit")
 	flag.Parse()
 
 	// 环境变量
 	if os.Getenv("MINIT_QUICK_EXIT") == "true" {
 		optQuickExit = true
 	}
+	if os.Getenv("MINIT_EXIT_WITH_MAIN") == "true" {
+		optExitWithMain = true
+	}
+	if val := strings.TrimSpace(os.Getenv("MINIT_MAIN_STDIN")); val != "" {
+		optMainStdin = val
+	}
+	if optMainStdin = strings.ToLower(strings.TrimSpace(optMainStdin)); optMainStdin != "" && optMainStdin != StdinNull && optMainStdin != StdinInherit {
+		err = fmt.Errorf("无效的 main-stdin 参数 %s，可选值为 null 或者 inherit", optMainStdin)
+		return
+	}
+	if val := strings.TrimSpace(os.Getenv("MINIT_SHUTDOWN_TIMEOUT")); val != "" {
+		if optShutdownTimeout, err = time.ParseDuration(val); err != nil {
+			err = fmt.Errorf("无效的环境变量 MINIT_SHUTDOWN_TIMEOUT=%s: %s", val, err.Error())
+			return
+		}
+	}
 
 	// 确保配置单元目录
 	if err = os.MkdirAll(optUnitDir, 0755); err != nil {
@@ -92,6 +164,11 @@ func main() {
 		return
 	}
 
+	// 僵尸进程回收
+	if err = setupReaper(); err != nil {
+		return
+	}
+
 	// 载入单元
 	var units []Unit
 	if units, err = LoadDir(optUnitDir); err != nil {
@@ -119,6 +196,7 @@ func main() {
 	}
 
 	// 检查单元命名
+	var useCgroup bool
 	unitNames := map[string]bool{"minit": true}
 	for _, unit := range units {
 		if unit.Name == "" {
@@ -134,11 +212,46 @@ func main() {
 			return
 		}
 		unitNames[unit.Name] = true
+		if unit.Main && unit.Kind != KindDaemon {
+			err = fmt.Errorf("单元 %s 不是 daemon 类型，不能设置为主单元，检查 main 字段", unit.Name)
+			return
+		}
+		if unit.Critical && unit.Kind != KindOnce && unit.Kind != KindDaemon && unit.Kind != KindCron {
+			err = fmt.Errorf("单元 %s 不是 once, daemon 或者 cron 类型，不能设置为关键单元，检查 critical 字段", unit.Name)
+			return
+		}
+		if settings, err1 := unit.cgroupSettings(); err1 != nil {
+			err = fmt.Errorf("单元 %s 的 cgroup 配置错误: %s", unit.Name, err1.Error())
+			return
+		} else if len(settings) > 0 {
+			useCgroup = true
+		}
 		log.Printf("载入单元 %s/%s", unit.Kind, unit.Name)
 	}
 
+	// cgroup v2
+	if useCgroup {
+		if err = setupCgroup(); err != nil {
+			return
+		}
+	}
+
+	// 解析依赖关系
+	var deps map[string][]string
+	if units, deps, err = resolveUnitDependencies(units); err != nil {
+		return
+	}
+
+	// 单元之间的管道
+	if err = setupUnitPipes(units); err != nil {
+		return
+	}
+
 	// 控制器组, L1 是 render (渲染配置文件), L2 是 once (一次性命令), L3 是 daemon 和 cron
-	runners := map[RunnerLevel][]Runner{}
+	runners := map[RunnerLevel][]UnitRunner{}
+
+	// 需要转发的信号
+	forwardSignals := map[syscall.Signal]bool{}
 
 	// 创建控制器
 	for _, unit := range units {
@@ -147,6 +260,16 @@ func main() {
 			err = fmt.Errorf("单元 %s 类型 %s 未知，检查 kind 字段", unit.Name, unit.Kind)
 			return
 		}
+		unit.unitName = unit.Name
+
+		var forwards map[syscall.Signal]syscall.Signal
+		if forwards, err = parseForwardSignals(unit.ForwardSignals); err != nil {
+			err = fmt.Errorf("单元 %s 信号转发规则错误，检查 forward_signals 字段: %s", unit.Name, err.Error())
+			return
+		}
+		for sig := range forwards {
+			forwardSignals[sig] = true
+		}
 
 		var logger *mlog.Logger
 		if logger, err = mlog.NewLogger(mlog.LoggerOptions{
@@ -164,16 +287,29 @@ func main() {
 			return
 		}
 
-		runners[fac.Level] = append(runners[fac.Level], runner)
+		runners[fac.Level] = append(runners[fac.Level], UnitRunner{Unit: unit, Runner: runner})
 	}
 
+	// 主环境
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	// 运行 L1 控制器
 	for _, runner := range runners[RunnerL1] {
-		runner.Run(context.Background())
+		runner.Run(ctx)
 	}
 	// 运行 L2 控制器
 	for _, runner := range runners[RunnerL2] {
-		runner.Run(context.Background())
+		runner.Run(ctx)
+
+		// 关键单元失败
+		select {
+		case req := <-chShutdown:
+			code = req.code
+			err = fmt.Errorf("关键单元 %s 失败: %s", req.name, req.reason)
+			return
+		default:
+		}
 	}
 
 	if len(runners[RunnerL3]) == 0 && optQuickExit {
@@ -181,33 +317,120 @@ func main() {
 		return
 	}
 
-	// 运行 L3 控制器
-	ctx, cancel := context.WithCancel(context.Background())
-	wg := &sync.WaitGroup{}
+	// 运行 L3 控制器，每个控制器使用独立的环境，以便按照依赖关系逆序关闭
+	var (
+		l3Names   []string
+		l3Cancels = map[string]context.CancelFunc{}
+		l3Dones   = map[string]chan struct{}{}
+	)
 
 	for _, runner := range runners[RunnerL3] {
-		wg.Add(1)
-		go func(runner Runner) {
+		runnerCtx, runnerCancel := context.WithCancel(ctx)
+		chDone := make(chan struct{})
+		l3Names = append(l3Names, runner.Name)
+		l3Cancels[runner.Name] = runnerCancel
+		l3Dones[runner.Name] = chDone
+
+		go func(ctx context.Context, runner UnitRunner) {
+			defer close(chDone)
+			// 等待依赖的单元就绪
+			for _, dep := range deps[runner.Name] {
+				log.Printf("单元 %s 等待单元 %s 就绪", runner.Name, dep)
+				if !waitUnitReady(ctx, dep) {
+					return
+				}
+			}
 			runner.Run(ctx)
-			wg.Done()
-		}(runner)
+		}(runnerCtx, runner)
 	}
 
-	log.Printf("启动完毕")
+	// 转发信号
+	if len(forwardSignals) > 0 {
+		chFwd := make(chan os.Signal, 8)
+		for sig := range forwardSignals {
+			signal.Notify(chFwd, sig)
+		}
+		go func() {
+			for sig := range chFwd {
+				log.Printf("接收到信号: %s，按照 forward_signals 转发", sig.String())
+				forwardSignal(sig.(syscall.Signal))
+			}
+		}()
+	}
 
-	// 等待信号并退出
+	// 等待所有 L3 单元就绪
+	go func() {
+		for _, runner := range runners[RunnerL3] {
+			if !waitUnitReady(ctx, runner.Name) {
+				return
+			}
+		}
+		log.Printf("启动完毕")
+	}()
+
+	// 等待信号或者主单元退出
 	chSig := make(chan os.Signal, 1)
 	signal.Notify(chSig, syscall.SIGINT, syscall.SIGTERM)
-	sig := <-chSig
-	log.Printf("接收到信号: %s", sig.String())
 
-	// 关闭主环境
-	cancel()
+	var sig os.Signal
+	select {
+	case sig = <-chSig:
+		log.Printf("接收到信号: %s", sig.String())
+	case req := <-chShutdown:
+		if req.failed {
+			log.Errorf("关键单元 %s 失败: %s", req.name, req.reason)
+			defer func() {
+				if err == nil {
+					err = fmt.Errorf("关键单元 %s 失败: %s", req.name, req.reason)
+				}
+			}()
+		} else {
+			log.Printf("单元 %s 请求关闭: %s", req.name, req.reason)
+		}
+		code = req.code
+	}
 
-	// 延迟 3 秒播发信号
-	time.Sleep(time.Second * 3)
-	notifyPIDs(sig)
+	shutdownAt := time.Now()
+
+	// 按照依赖关系逆序，分批关闭 L3 控制器，各控制器会按照 stop_signal 和 stop_timeout 停止进程
+	chDone := make(chan struct{})
+	go func() {
+		defer close(chDone)
+		for _, tier := range shutdownTiers(l3Names, deps) {
+			log.Printf("关闭单元: %s", strings.Join(tier, ", "))
+			for _, name := range tier {
+				l3Cancels[name]()
+			}
+			for _, name := range tier {
+				<-l3Dones[name]
+			}
+		}
+	}()
+
+	timer := time.NewTimer(optShutdownTimeout)
+	defer timer.Stop()
 
-	// 等待控制器退出
-	wg.Wait()
+	for err == nil {
+		select {
+		case <-chDone:
+			return
+		case <-timer.C:
+			err = fmt.Errorf("等待 %s 后仍有进程未退出，强制结束", optShutdownTimeout.String())
+		case sig = <-chSig:
+			if time.Since(shutdownAt) < forceShutdownInterval {
+				log.Printf("忽略重复接收到的信号: %s", sig.String())
+				continue
+			}
+			err = fmt.Errorf("再次接收到信号: %s，强制结束", sig.String())
+		}
+	}
+
+	log.Error(err.Error())
+	cancel()
+	notifyPIDs(syscall.SIGKILL)
+
+	select {
+	case <-chDone:
+	case <-time.After(time.Second * 3):
+	}
 }
@@ -2,11 +2,127 @@
 
 package main
 
-import "os/exec"
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+var (
+	knownSignalNames = map[string]syscall.Signal{
+		"HUP":  syscall.SIGHUP,
+		"INT":  syscall.SIGINT,
+		"KILL": syscall.SIGKILL,
+		"QUIT": syscall.SIGQUIT,
+		"TERM": syscall.SIGTERM,
+	}
+
+	unforwardableSignals = map[syscall.Signal]bool{
+		syscall.SIGINT:  true,
+		syscall.SIGTERM: true,
+		syscall.SIGKILL: true,
+	}
+)
 
 func setupCmdSysProcAttr(*exec.Cmd) {
 }
 
+func notifySocketAddress(name string) string {
+	addr := filepath.Join(os.TempDir(), fmt.Sprintf("minit-notify-%d-%s.sock", os.Getpid(), name))
+	_ = os.Remove(addr)
+	return addr
+}
+
+func setupCmdTTY(*exec.Cmd, int) {
+}
+
+func setupNotifyConn(*net.UnixConn) error {
+	return nil
+}
+
+func readNotifyConn(conn *net.UnixConn, buf []byte) (int, int, error) {
+	n, err := conn.Read(buf)
+	return n, 0, err
+}
+
+func notifySenderGroup(int) int {
+	return -1
+}
+
+func openPTY(rows, cols int) (*os.File, *os.File, error) {
+	return nil, nil, errors.New("仅支持在 Linux 上设置 tty 参数")
+}
+
+func setupCmdCredential(*exec.Cmd, *credential) error {
+	return errors.New("仅支持在 Linux 上设置 user 参数")
+}
+
+func resolveRLimits(items map[string]string) ([]helperRLimit, error) {
+	if len(items) > 0 {
+		return nil, errors.New("仅支持在 Linux 上设置 rlimits 参数")
+	}
+	return nil, nil
+}
+
+func resolveCapabilities(opts ExecuteOptions) (helperCapabilities, error) {
+	if opts.Capabilities != nil || len(opts.AmbientCapabilities) > 0 || opts.NoNewPrivs {
+		return helperCapabilities{}, errors.New("仅支持在 Linux 上设置 capabilities, ambient_capabilities 和 no_new_privs 参数")
+	}
+	return helperCapabilities{}, nil
+}
+
+func landlockABI() int {
+	return 0
+}
+
+func resolveLandlock(abi int, readOnly []string, readWrite []string) (*helperLandlock, error) {
+	return nil, nil
+}
+
+func setupHelperProcess(opts helperOptions) error {
+	return nil
+}
+
+func setupCgroup() error {
+	return nil
+}
+
+func createUnitCgroup(name string, settings []cgroupSetting) (*unitCgroup, error) {
+	return nil, nil
+}
+
+func applySched(pid int, s schedOptions) []error {
+	if !s.empty() {
+		return []error{errors.New("仅支持在 Linux 上设置 nice, ionice_class, oom_score_adj 和 cpu_affinity 参数")}
+	}
+	return nil
+}
+
+func helperExecutable() (string, error) {
+	return os.Executable()
+}
+
+func signalProcess(pid int, sig syscall.Signal, group bool) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Signal(sig)
+}
+
+func findProcessGroupMembers(pgid int) []procStat {
+	return nil
+}
+
+type procStat struct {
+	Pid  int
+	Comm string
+}
+
 func setupTHP() error {
 	return nil
 }
@@ -18,3 +134,7 @@ func setupSysctl() error {
 func setupRLimits() error {
 	return nil
 }
+
+func setupReaper() error {
+	return nil
+}
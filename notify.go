@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+const (
+	TypeSimple = "simple"
+	TypeNotify = "notify"
+)
+
+// notifyMessage sd_notify 协议的一条消息，比如 READY=1
+type notifyMessage struct {
+	Key   string
+	Value string
+	PGID  int // 发送者所属的进程组，接收时立即获取，避免发送者退出后无法获取；无法获取时为 0，不支持获取时为 -1
+}
+
+// notifySocket 实现 sd_notify 协议的数据报套接字，地址通过 NOTIFY_SOCKET 环境变量传递给子进程
+type notifySocket struct {
+	addr string
+	conn *net.UnixConn
+}
+
+func newNotifySocket(name string) (s *notifySocket, err error) {
+	s = &notifySocket{addr: notifySocketAddress(name)}
+	if s.conn, err = net.ListenUnixgram("unixgram", &net.UnixAddr{Name: s.addr, Net: "unixgram"}); err != nil {
+		return
+	}
+	if err = setupNotifyConn(s.conn); err != nil {
+		_ = s.conn.Close()
+		return
+	}
+	return
+}
+
+// Serve 持续读取消息，直到套接字被关闭
+func (s *notifySocket) Serve(ch chan notifyMessage) {
+	buf := make([]byte, 4096)
+	for {
+		n, pid, err := readNotifyConn(s.conn, buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		pgid := notifySenderGroup(pid)
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			splits := strings.SplitN(line, "=", 2)
+			if len(splits) != 2 {
+				continue
+			}
+			select {
+			case ch <- notifyMessage{Key: splits[0], Value: splits[1], PGID: pgid}:
+			default:
+			}
+		}
+	}
+}
+
+func (s *notifySocket) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,43 @@
+//+build linux
+
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNotifySocket(t *testing.T) {
+	s, err := newNotifySocket("test-notify")
+	require.NoError(t, err)
+	defer s.Close()
+
+	ch := make(chan notifyMessage, 16)
+	go s.Serve(ch)
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: s.addr, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("READY=1\nSTATUS=hello=world\ninvalid\nWATCHDOG=1"))
+	require.NoError(t, err)
+
+	var msgs []notifyMessage
+	for len(msgs) < 3 {
+		select {
+		case msg := <-ch:
+			msgs = append(msgs, msg)
+		case <-time.After(time.Second * 3):
+			t.Fatal("timeout")
+		}
+	}
+	// 消息附带发送者所属的进程组
+	pgid := syscall.Getpgrp()
+	require.Equal(t, []notifyMessage{
+		{Key: "READY", Value: "1", PGID: pgid},
+		{Key: "STATUS", Value: "hello=world", PGID: pgid},
+		{Key: "WATCHDOG", Value: "1", PGID: pgid},
+	}, msgs)
+}
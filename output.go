@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	OutputLog      = "log"
+	OutputNull     = "null"
+	OutputFile     = "file"
+	OutputTruncate = "truncate"
+	OutputUnit     = "unit"
+)
+
+var (
+	// unitPipes 通过 unit:<name> 连接到其他单元标准输入的管道，按照目标单元名称索引，由 main 创建
+	unitPipes = map[string]*unitPipe{}
+)
+
+// unitPipe 连接单元之间的管道，minit 始终持有两端，目标单元重启期间，写入的内容保留在管道中
+type unitPipe struct {
+	r *os.File
+	w *os.File
+}
+
+// outputTarget 标准输出或者标准错误的去向，格式为 log, null, file:路径, truncate:路径 或者 unit:单元名称
+type outputTarget struct {
+	Kind string
+	Path string // file, truncate
+	Unit string // unit
+}
+
+func parseOutputTarget(s string) (t outputTarget, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == OutputLog {
+		t.Kind = OutputLog
+		return
+	}
+	if s == OutputNull {
+		t.Kind = OutputNull
+		return
+	}
+	splits := strings.SplitN(s, ":", 2)
+	if len(splits) == 2 && strings.TrimSpace(splits[1]) != "" {
+		val := strings.TrimSpace(splits[1])
+		switch strings.TrimSpace(splits[0]) {
+		case OutputFile, "append":
+			t.Kind, t.Path = OutputFile, val
+			return
+		case OutputTruncate:
+			t.Kind, t.Path = OutputTruncate, val
+			return
+		case OutputUnit:
+			t.Kind, t.Unit = OutputUnit, val
+			return
+		}
+	}
+	err = fmt.Errorf("无效的输出 %s，格式为 log, null, file:路径, truncate:路径 或者 unit:单元名称", s)
+	return
+}
+
+// output 进程的一路输出
+type output struct {
+	w     *os.File // 传递给进程的写入端，nil 表示 /dev/null
+	r     *os.File // 记录到日志时的读取端
+	owned bool     // 写入端是否由本次执行创建，进程启动后需要关闭
+}
+
+// open 打开输出，log 使用管道，由 minit 读取后记录到日志
+func (t outputTarget) open(dir string, env *environment) (o output, err error) {
+	switch t.Kind {
+	case OutputLog:
+		if o.r, o.w, err = os.Pipe(); err != nil {
+			return
+		}
+		o.owned = true
+	case OutputNull:
+	case OutputFile, OutputTruncate:
+		// 相对路径以 dir 为基准
+		name := env.Expand(t.Path)
+		if !filepath.IsAbs(name) && dir != "" {
+			name = filepath.Join(dir, name)
+		}
+		flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		if t.Kind == OutputTruncate {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		}
+		if o.w, err = os.OpenFile(name, flag, 0644); err != nil {
+			return
+		}
+		o.owned = true
+	case OutputUnit:
+		p := unitPipes[t.Unit]
+		if p == nil {
+			err = fmt.Errorf("未找到单元 %s 的管道", t.Unit)
+			return
+		}
+		o.w = p.w
+	}
+	return
+}
+
+// closeWriter 进程启动后，关闭 minit 持有的写入端
+func (o output) closeWriter() {
+	if o.owned && o.w != nil {
+		_ = o.w.Close()
+	}
+}
+
+// close 关闭全部文件
+func (o output) close() {
+	o.closeWriter()
+	if o.r != nil {
+		_ = o.r.Close()
+	}
+}
+
+// setupUnitPipes 检查 stdout 和 stderr 字段，为 unit:<name> 引用的单元创建管道，管道在 minit 运行期间一直保持打开
+func setupUnitPipes(units []Unit) (err error) {
+	byName := map[string]Unit{}
+	for _, unit := range units {
+		byName[unit.Name] = unit
+	}
+	for _, unit := range units {
+		for _, item := range []struct {
+			field string
+			value string
+		}{
+			{"stdout", unit.Stdout},
+			{"stderr", unit.Stderr},
+		} {
+			var t outputTarget
+			if t, err = parseOutputTarget(item.value); err != nil {
+				err = fmt.Errorf("单元 %s 的 %s 字段错误: %s", unit.Name, item.field, err.Error())
+				return
+			}
+			if t.Kind != OutputLog && unit.TTY {
+				err = fmt.Errorf("单元 %s 使用了 tty，不能设置 %s 字段", unit.Name, item.field)
+				return
+			}
+			if t.Kind != OutputUnit {
+				continue
+			}
+			// L3 单元在所有 L2 单元结束后才启动，L2 单元写满管道后会一直阻塞
+			if fac := RunnerFactories[unit.Kind]; fac != nil && fac.Level < RunnerL3 {
+				err = fmt.Errorf("单元 %s 不是 daemon 或者 cron 类型，%s 字段不能使用 unit:", unit.Name, item.field)
+				return
+			}
+			target, ok := byName[t.Unit]
+			if !ok {
+				err = fmt.Errorf("单元 %s 的 %s 字段引用的单元 %s 不存在", unit.Name, item.field, t.Unit)
+				return
+			}
+			if target.Name == unit.Name {
+				err = fmt.Errorf("单元 %s 的 %s 字段不能引用自身", unit.Name, item.field)
+				return
+			}
+			if target.Kind != KindDaemon {
+				err = fmt.Errorf("单元 %s 的 %s 字段引用的单元 %s 不是 daemon 类型", unit.Name, item.field, t.Unit)
+				return
+			}
+			if target.Stdin.Mode != "" {
+				err = fmt.Errorf("单元 %s 的 %s 字段引用的单元 %s 已经设置了 stdin 字段", unit.Name, item.field, t.Unit)
+				return
+			}
+			if unitPipes[t.Unit] == nil {
+				p := &unitPipe{}
+				if p.r, p.w, err = os.Pipe(); err != nil {
+					return
+				}
+				unitPipes[t.Unit] = p
+			}
+			log.Printf("连接单元 %s 的 %s 到单元 %s 的标准输入", unit.Name, item.field, t.Unit)
+		}
+	}
+	return
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseOutputTarget(t *testing.T) {
+	for s, expected := range map[string]outputTarget{
+		"":                 {Kind: OutputLog},
+		"log":              {Kind: OutputLog},
+		"null":             {Kind: OutputNull},
+		"file:/var/log/a":  {Kind: OutputFile, Path: "/var/log/a"},
+		"append: a.log":    {Kind: OutputFile, Path: "a.log"},
+		"truncate:/tmp/b":  {Kind: OutputTruncate, Path: "/tmp/b"},
+		"unit:log-shipper": {Kind: OutputUnit, Unit: "log-shipper"},
+		" file:C:\\a.log ": {Kind: OutputFile, Path: "C:\\a.log"},
+	} {
+		target, err := parseOutputTarget(s)
+		require.NoError(t, err, s)
+		require.Equal(t, expected, target, s)
+	}
+	for _, s := range []string{"console", "file:", "unit:", "pipe:a"} {
+		_, err := parseOutputTarget(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestUnitUnmarshalYAMLOutput(t *testing.T) {
+	for doc, expected := range map[string][2]string{
+		"name: a":                                 {"", ""},
+		"name: a\nstdout: null\nstderr: ~":        {OutputNull, OutputNull},
+		"name: a\nstdout: \"null\"\nstderr: log":  {OutputNull, OutputLog},
+		"name: a\nstdout: file:out\nstderr: null": {"file:out", OutputNull},
+	} {
+		var unit Unit
+		require.NoError(t, yaml.Unmarshal([]byte(doc), &unit), doc)
+		require.Equal(t, expected, [2]string{unit.Stdout, unit.Stderr}, doc)
+	}
+}
+
+func TestOutputTargetOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minit-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "out.log")
+	require.NoError(t, ioutil.WriteFile(name, []byte("old\n"), 0644))
+
+	env := newEnvironment()
+	for _, item := range []struct {
+		target   outputTarget
+		expected string
+	}{
+		{outputTarget{Kind: OutputFile, Path: "out.log"}, "old\nnew\n"},
+		{outputTarget{Kind: OutputTruncate, Path: name}, "new\n"},
+	} {
+		o, err := item.target.open(dir, env)
+		require.NoError(t, err)
+		require.True(t, o.owned)
+		require.Nil(t, o.r)
+		_, err = o.w.WriteString("new\n")
+		require.NoError(t, err)
+		o.close()
+		buf, err := ioutil.ReadFile(name)
+		require.NoError(t, err)
+		require.Equal(t, item.expected, string(buf))
+	}
+
+	o, err := outputTarget{Kind: OutputLog}.open(dir, env)
+	require.NoError(t, err)
+	_, err = o.w.WriteString("hello\n")
+	require.NoError(t, err)
+	o.closeWriter()
+	buf, err := ioutil.ReadAll(o.r)
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", string(buf))
+	o.close()
+
+	o, err = outputTarget{Kind: OutputNull}.open(dir, env)
+	require.NoError(t, err)
+	require.Nil(t, o.w)
+
+	_, err = outputTarget{Kind: OutputUnit, Unit: "missing"}.open(dir, env)
+	require.Error(t, err)
+}
+
+func TestSetupUnitPipesValidation(t *testing.T) {
+	daemon := Unit{Name: "shipper", Kind: KindDaemon}
+	for _, units := range [][]Unit{
+		{{Name: "app", Kind: KindDaemon, ExecuteOptions: ExecuteOptions{Stdout: "unit:missing"}}},
+		{{Name: "app", Kind: KindDaemon, ExecuteOptions: ExecuteOptions{Stdout: "unit:app"}}},
+		{{Name: "app", Kind: KindDaemon, ExecuteOptions: ExecuteOptions{Stderr: "unit:job"}}, {Name: "job", Kind: KindOnce}},
+		{{Name: "app", Kind: KindDaemon, ExecuteOptions: ExecuteOptions{Stdout: "unit:shipper", TTY: true}}, daemon},
+		{{Name: "app", Kind: KindDaemon, ExecuteOptions: ExecuteOptions{Stdout: "unit:shipper"}}, {Name: "shipper", Kind: KindDaemon, ExecuteOptions: ExecuteOptions{Stdin: StdinOptions{Mode: StdinInherit}}}},
+		{{Name: "app", Kind: KindDaemon, ExecuteOptions: ExecuteOptions{Stdout: "unit:shipper"}}, {Name: "shipper", Kind: KindDaemon, ExecuteOptions: ExecuteOptions{Stdin: StdinOptions{Mode: StdinNull}}}},
+		{{Name: "app", Kind: KindDaemon, ExecuteOptions: ExecuteOptions{Stdout: "console"}}},
+		{{Name: "job", Kind: KindOnce, ExecuteOptions: ExecuteOptions{Stdout: "unit:shipper"}}, daemon},
+	} {
+		require.Error(t, setupUnitPipes(units))
+	}
+	require.NoError(t, setupUnitPipes([]Unit{{Name: "app", Kind: KindDaemon, ExecuteOptions: ExecuteOptions{Stdout: "file:/tmp/app.log"}}, daemon}))
+	require.Empty(t, unitPipes)
+}
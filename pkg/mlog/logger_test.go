@@ -7,7 +7,7 @@ import (
 )
 
 func TestLog(t *testing.T) {
-	log, err := NewLogger(os.TempDir(), "test", "test")
+	log, err := NewLogger(LoggerOptions{Dir: os.TempDir(), Name: "test", Filename: "test"})
 	require.NoError(t, err)
 	log.Print("hello", "world")
 	log.Printf("hello, %s", "world")
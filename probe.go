@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/acicn/minit/pkg/mlog"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+const (
+	DefaultProbeInterval         = time.Second * 5
+	DefaultProbeTimeout          = time.Second * 3
+	DefaultProbeSuccessThreshold = 1
+	DefaultProbeFailureThreshold = 3
+
+	probeBodyLimit = 64 * 1024
+)
+
+// Probe 探针，用于检查进程是否就绪或者存活，exec, tcp, http, unix 只能指定一种
+type Probe struct {
+	Exec       []string `yaml:"exec"`        // 执行命令，退出码为 0 视为成功
+	TCP        string   `yaml:"tcp"`         // 连接 TCP 地址，比如 127.0.0.1:6379
+	HTTP       string   `yaml:"http"`        // 请求 HTTP 地址，比如 http://127.0.0.1:8080/healthz
+	HTTPStatus int      `yaml:"http_status"` // 期望的 HTTP 状态码，默认为 200 ~ 399
+	HTTPBody   string   `yaml:"http_body"`   // 期望 HTTP 响应包含的内容
+	Unix       string   `yaml:"unix"`        // 连接 unix socket 地址
+
+	InitialDelay     time.Duration `yaml:"initial_delay"`     // 进程启动后，首次检查前的等待时间
+	Interval         time.Duration `yaml:"interval"`          // 检查间隔，默认 5s
+	Timeout          time.Duration `yaml:"timeout"`           // 单次检查超时时间，默认 3s
+	SuccessThreshold int           `yaml:"success_threshold"` // 连续成功次数达到此值后视为成功，默认 1
+	FailureThreshold int           `yaml:"failure_threshold"` // 连续失败次数达到此值后视为失败，默认 3
+}
+
+// validate 检查探针配置，并设置默认值
+func (p *Probe) validate() error {
+	var count int
+	for _, ok := range []bool{len(p.Exec) > 0, p.TCP != "", p.HTTP != "", p.Unix != ""} {
+		if ok {
+			count++
+		}
+	}
+	if count != 1 {
+		return fmt.Errorf("exec, tcp, http, unix 必须且只能指定一种")
+	}
+	if p.Interval <= 0 {
+		p.Interval = DefaultProbeInterval
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = DefaultProbeTimeout
+	}
+	if p.SuccessThreshold <= 0 {
+		p.SuccessThreshold = DefaultProbeSuccessThreshold
+	}
+	if p.FailureThreshold <= 0 {
+		p.FailureThreshold = DefaultProbeFailureThreshold
+	}
+	return nil
+}
+
+// check 执行一次检查
+func (p *Probe) check(ctx context.Context) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	switch {
+	case len(p.Exec) > 0:
+		return p.checkExec(ctx)
+	case p.TCP != "":
+		return p.checkDial(ctx, "tcp", p.TCP)
+	case p.Unix != "":
+		return p.checkDial(ctx, "unix", p.Unix)
+	case p.HTTP != "":
+		return p.checkHTTP(ctx)
+	}
+	return
+}
+
+func (p *Probe) checkExec(ctx context.Context) (err error) {
+	argv := make([]string, 0, len(p.Exec))
+	for _, arg := range p.Exec {
+		argv = append(argv, os.ExpandEnv(arg))
+	}
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	// 记录 Pid，避免被 reapZombies 提前回收
+	child := &childProcess{}
+	if err = startChild(cmd, child); err != nil {
+		return
+	}
+	defer removeChild(child)
+	return cmd.Wait()
+}
+
+func (p *Probe) checkDial(ctx context.Context, network, address string) (err error) {
+	var conn net.Conn
+	if conn, err = (&net.Dialer{}).DialContext(ctx, network, address); err != nil {
+		return
+	}
+	return conn.Close()
+}
+
+func (p *Probe) checkHTTP(ctx context.Context) (err error) {
+	var req *http.Request
+	if req, err = http.NewRequest(http.MethodGet, p.HTTP, nil); err != nil {
+		return
+	}
+	var res *http.Response
+	if res, err = http.DefaultClient.Do(req.WithContext(ctx)); err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	if p.HTTPStatus != 0 {
+		if res.StatusCode != p.HTTPStatus {
+			err = fmt.Errorf("HTTP 状态码 %d 不等于 %d", res.StatusCode, p.HTTPStatus)
+			return
+		}
+	} else if res.StatusCode < 200 || res.StatusCode >= 400 {
+		err = fmt.Errorf("HTTP 状态码 %d 不在 200 ~ 399 之间", res.StatusCode)
+		return
+	}
+
+	if p.HTTPBody != "" {
+		var buf []byte
+		if buf, err = ioutil.ReadAll(io.LimitReader(res.Body, probeBodyLimit)); err != nil {
+			return
+		}
+		if !bytes.Contains(buf, []byte(p.HTTPBody)) {
+			err = fmt.Errorf("HTTP 响应不包含 %s", p.HTTPBody)
+			return
+		}
+	}
+	return
+}
+
+// sleep 等待指定时间，ctx 结束时返回 false
+func (p *Probe) sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// waitReady 周期性检查，直到连续成功 success_threshold 次，ctx 结束时返回 false
+func (p *Probe) waitReady(ctx context.Context, logger *mlog.Logger) bool {
+	if !p.sleep(ctx, p.InitialDelay) {
+		return false
+	}
+	var successes int
+	for {
+		if err := p.check(ctx); err != nil {
+			if ctx.Err() != nil {
+				return false
+			}
+			successes = 0
+			logger.Printf("就绪检查未通过: %s", err.Error())
+		} else {
+			successes++
+			if successes >= p.SuccessThreshold {
+				return true
+			}
+		}
+		if !p.sleep(ctx, p.Interval) {
+			return false
+		}
+	}
+}
+
+// watchAlive 周期性检查，连续失败 failure_threshold 次后返回 false，ctx 结束时返回 true
+func (p *Probe) watchAlive(ctx context.Context, logger *mlog.Logger) bool {
+	if !p.sleep(ctx, p.InitialDelay) {
+		return true
+	}
+	var failures int
+	for {
+		if err := p.check(ctx); err != nil {
+			if ctx.Err() != nil {
+				return true
+			}
+			failures++
+			logger.Errorf("存活检查失败 (%d/%d): %s", failures, p.FailureThreshold, err.Error())
+			if failures >= p.FailureThreshold {
+				return false
+			}
+		} else {
+			failures = 0
+		}
+		if !p.sleep(ctx, p.Interval) {
+			return true
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProbeValidate(t *testing.T) {
+	p := &Probe{}
+	require.Error(t, p.validate())
+	p = &Probe{TCP: "127.0.0.1:80", HTTP: "http://127.0.0.1"}
+	require.Error(t, p.validate())
+	p = &Probe{TCP: "127.0.0.1:80"}
+	require.NoError(t, p.validate())
+	require.Equal(t, DefaultProbeInterval, p.Interval)
+	require.Equal(t, DefaultProbeFailureThreshold, p.FailureThreshold)
+}
+
+func TestProbeCheck(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/fail" {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, _ = fmt.Fprint(rw, "status: ok")
+	}))
+	defer s.Close()
+
+	check := func(p Probe) error {
+		require.NoError(t, p.validate())
+		return p.check(context.Background())
+	}
+
+	require.NoError(t, check(Probe{HTTP: s.URL}))
+	require.NoError(t, check(Probe{HTTP: s.URL, HTTPBody: "ok"}))
+	require.Error(t, check(Probe{HTTP: s.URL, HTTPBody: "bad"}))
+	require.Error(t, check(Probe{HTTP: s.URL + "/fail"}))
+	require.NoError(t, check(Probe{HTTP: s.URL + "/fail", HTTPStatus: http.StatusServiceUnavailable}))
+
+	require.NoError(t, check(Probe{TCP: s.Listener.Addr().String()}))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	require.Error(t, check(Probe{TCP: addr}))
+
+	require.NoError(t, check(Probe{Exec: []string{"true"}}))
+	require.Error(t, check(Probe{Exec: []string{"false"}}))
+}
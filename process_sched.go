@@ -0,0 +1,45 @@
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"strconv"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+// applySched 在进程启动后设置调度参数
+func applySched(pid int, s schedOptions) (errs []error) {
+	if s.Nice != nil {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, pid, *s.Nice); err != nil {
+			errs = append(errs, fmt.Errorf("无法设置 nice=%d: %s", *s.Nice, err.Error()))
+		}
+	}
+	if s.IONiceClass != 0 {
+		prio := s.IONiceClass<<ioprioClassShift | s.IONiceLevel
+		if _, _, e := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(pid), uintptr(prio)); e != 0 {
+			errs = append(errs, fmt.Errorf("无法设置 ionice: %s", e.Error()))
+		}
+	}
+	if s.OOMScoreAdj != nil {
+		if err := ioutil.WriteFile(fmt.Sprintf("/proc/%d/oom_score_adj", pid), []byte(strconv.Itoa(*s.OOMScoreAdj)), 0644); err != nil {
+			errs = append(errs, fmt.Errorf("无法设置 oom_score_adj=%d: %s", *s.OOMScoreAdj, err.Error()))
+		}
+	}
+	if len(s.CPUs) > 0 {
+		var set unix.CPUSet
+		for _, cpu := range s.CPUs {
+			set.Set(cpu)
+		}
+		if err := unix.SchedSetaffinity(pid, &set); err != nil {
+			errs = append(errs, fmt.Errorf("无法设置 cpu_affinity: %s", err.Error()))
+		}
+	}
+	return
+}
@@ -0,0 +1,76 @@
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// procStat 进程状态，取自 /proc/[pid]/stat
+type procStat struct {
+	Pid   int
+	Comm  string
+	State byte
+	PPid  int
+	PGrp  int
+}
+
+func readProcStat(pid int) (st procStat, err error) {
+	var buf []byte
+	if buf, err = ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat")); err != nil {
+		return
+	}
+	// comm 字段可能包含空格和括号，以最后一个 ')' 为界
+	s := string(buf)
+	l, r := strings.IndexByte(s, '('), strings.LastIndexByte(s, ')')
+	if l < 0 || r < l {
+		err = fmt.Errorf("无法解析 /proc/%d/stat", pid)
+		return
+	}
+	fields := strings.Fields(s[r+1:])
+	if len(fields) < 3 || len(fields[0]) == 0 {
+		err = fmt.Errorf("无法解析 /proc/%d/stat", pid)
+		return
+	}
+	st.Pid = pid
+	st.Comm = s[l+1 : r]
+	st.State = fields[0][0]
+	if st.PPid, err = strconv.Atoi(fields[1]); err != nil {
+		return
+	}
+	if st.PGrp, err = strconv.Atoi(fields[2]); err != nil {
+		return
+	}
+	return
+}
+
+// listProcStats 列出所有进程的状态
+func listProcStats() (sts []procStat) {
+	fis, _ := ioutil.ReadDir("/proc")
+	for _, fi := range fis {
+		pid, err := strconv.Atoi(fi.Name())
+		if err != nil {
+			continue
+		}
+		st, err := readProcStat(pid)
+		if err != nil {
+			continue
+		}
+		sts = append(sts, st)
+	}
+	return
+}
+
+// findProcessGroupMembers 找出进程组内所有存活的进程，不包括僵尸进程
+func findProcessGroupMembers(pgid int) (sts []procStat) {
+	for _, st := range listProcStats() {
+		if st.PGrp == pgid && st.State != 'Z' && st.State != 'X' {
+			sts = append(sts, st)
+		}
+	}
+	return
+}
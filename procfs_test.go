@@ -0,0 +1,19 @@
+//+build linux
+
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestReadProcStat(t *testing.T) {
+	st, err := readProcStat(os.Getpid())
+	require.NoError(t, err)
+	require.Equal(t, os.Getpid(), st.Pid)
+	require.Equal(t, os.Getppid(), st.PPid)
+	require.Equal(t, syscall.Getpgrp(), st.PGrp)
+	require.NotEmpty(t, st.Comm)
+}
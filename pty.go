@@ -0,0 +1,62 @@
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// openPTY 通过 /dev/ptmx 分配伪终端，返回主设备和从设备
+func openPTY(rows, cols int) (master *os.File, slave *os.File, err error) {
+	var mfd int
+	// 主设备使用非阻塞模式，以便 Close 可以中断正在进行的读取
+	if mfd, err = unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC|unix.O_NONBLOCK, 0); err != nil {
+		return
+	}
+	master = os.NewFile(uintptr(mfd), "/dev/ptmx")
+	defer func() {
+		if err != nil {
+			_ = master.Close()
+			master = nil
+		}
+	}()
+
+	// 解锁从设备并获取编号
+	if err = unix.IoctlSetPointerInt(mfd, unix.TIOCSPTLCK, 0); err != nil {
+		return
+	}
+	var n uint32
+	if n, err = unix.IoctlGetUint32(mfd, unix.TIOCGPTN); err != nil {
+		return
+	}
+	name := fmt.Sprintf("/dev/pts/%d", n)
+
+	var sfd int
+	if sfd, err = unix.Open(name, unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0); err != nil {
+		return
+	}
+	slave = os.NewFile(uintptr(sfd), name)
+	defer func() {
+		if err != nil {
+			_ = slave.Close()
+			slave = nil
+		}
+	}()
+
+	// 关闭 \n 到 \r\n 的转换，保持日志行尾与管道一致
+	var termios *unix.Termios
+	if termios, err = unix.IoctlGetTermios(sfd, unix.TCGETS); err != nil {
+		return
+	}
+	termios.Oflag &^= unix.ONLCR
+	if err = unix.IoctlSetTermios(sfd, unix.TCSETS, termios); err != nil {
+		return
+	}
+
+	if err = unix.IoctlSetWinsize(sfd, unix.TIOCSWINSZ, &unix.Winsize{Row: uint16(rows), Col: uint16(cols)}); err != nil {
+		return
+	}
+	return
+}
@@ -0,0 +1,28 @@
+//+build linux
+
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+	"testing"
+)
+
+func TestOpenPTY(t *testing.T) {
+	master, slave, err := openPTY(40, 120)
+	require.NoError(t, err)
+	defer master.Close()
+	defer slave.Close()
+
+	ws, err := unix.IoctlGetWinsize(int(slave.Fd()), unix.TIOCGWINSZ)
+	require.NoError(t, err)
+	require.Equal(t, uint16(40), ws.Row)
+	require.Equal(t, uint16(120), ws.Col)
+
+	_, err = slave.Write([]byte("hello\n"))
+	require.NoError(t, err)
+	buf := make([]byte, 16)
+	n, err := master.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", string(buf[:n]))
+}
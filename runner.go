@@ -56,3 +56,9 @@ var (
 type Runner interface {
 	Run(ctx context.Context)
 }
+
+// UnitRunner 单元及其控制器
+type UnitRunner struct {
+	Unit
+	Runner
+}
@@ -18,11 +18,16 @@ func (r *CronRunner) Run(ctx context.Context) {
 	r.logger.Printf("控制器启动")
 	defer r.logger.Printf("控制器退出")
 
+	markUnitReady(r.Name)
+
 	cr := cron.New(cron.WithLogger(cron.PrintfLogger(r.logger)))
 	_, err := cr.AddFunc(r.Cron, func() {
 		r.logger.Printf("定时任务触发")
-		_ = execute(r.ExecuteOptions, r.logger)
+		err := execute(ctx, r.ExecuteOptions, r.logger)
 		r.logger.Printf("定时任务结束")
+		if r.Critical && ctx.Err() == nil && !r.IsSuccess(err) {
+			requestCriticalShutdown(r.Name, err.Error())
+		}
 	})
 	if err != nil {
 		// 已经检查过表达式了，不应该报错
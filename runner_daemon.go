@@ -4,19 +4,258 @@ import (
 	"context"
 	"fmt"
 	"github.com/acicn/minit/pkg/mlog"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const KindDaemon = "daemon"
 
+const (
+	RestartAlways    = "always"
+	RestartOnFailure = "on-failure"
+	RestartNever     = "never"
+)
+
+const (
+	DefaultRestartDelay       = time.Second * 5
+	DefaultRestartDelayMax    = time.Minute
+	DefaultRestartMultiplier  = 2
+	DefaultRestartReset       = time.Minute
+	DefaultStartLimitInterval = time.Minute * 10
+)
+
+// restartBackoff 计算指数退避的重启等待时间
+type restartBackoff struct {
+	initial    time.Duration
+	max        time.Duration
+	multiplier float64
+	jitter     float64
+	reset      time.Duration
+	rnd        *rand.Rand
+
+	current time.Duration
+}
+
+// next 根据上次进程的运行时长，计算下一次重启前的等待时间
+func (b *restartBackoff) next(uptime time.Duration) time.Duration {
+	if b.current == 0 || uptime >= b.reset {
+		b.current = b.initial
+	} else {
+		b.current = time.Duration(float64(b.current) * b.multiplier)
+		if b.current > b.max {
+			b.current = b.max
+		}
+	}
+	delay := b.current
+	if b.jitter > 0 {
+		delay += time.Duration((b.rnd.Float64()*2 - 1) * b.jitter * float64(delay))
+	}
+	return delay
+}
+
 type DaemonRunner struct {
 	Unit
 	logger *mlog.Logger
+
+	backoff  *restartBackoff
+	restarts []time.Time
+
+	notify   *notifySocket
+	chNotify chan notifyMessage
+
+	sockets []*os.File
+}
+
+// isFailure 判断进程是否异常退出，reason 不为空表示进程被存活检查或者看门狗停止，无论退出码如何均视为失败
+func (r *DaemonRunner) isFailure(err error, reason string) bool {
+	return reason != "" || !r.IsSuccess(err)
+}
+
+// shouldRestart 根据重启策略和进程退出情况，判断是否需要重启
+func (r *DaemonRunner) shouldRestart(err error, reason string) bool {
+	switch r.Restart {
+	case RestartNever:
+		return false
+	case RestartOnFailure:
+		return r.isFailure(err, reason)
+	default:
+		return true
+	}
+}
+
+// checkStartLimit 记录一次重启，并检查时间窗口内的重启次数是否超过 start_limit
+func (r *DaemonRunner) checkStartLimit(now time.Time) bool {
+	if r.StartLimit <= 0 {
+		return true
+	}
+	restarts := r.restarts[:0]
+	for _, t := range r.restarts {
+		if now.Sub(t) < r.StartLimitInterval {
+			restarts = append(restarts, t)
+		}
+	}
+	r.restarts = restarts
+	if len(r.restarts) >= r.StartLimit {
+		return false
+	}
+	r.restarts = append(r.restarts, now)
+	return true
+}
+
+// staggerDelay 副本错开启动的等待时间
+func (r *DaemonRunner) staggerDelay() time.Duration {
+	return time.Duration(r.replica) * r.ReplicaStagger
+}
+
+// onStart 进程启动后，执行就绪检查和存活检查，ctx 在进程退出后结束，pid 为主进程的进程号，调用 stop 停止进程
+func (r *DaemonRunner) onStart(ctx context.Context, pid int, stop func(reason string)) {
+	var chNotifyReady chan struct{}
+	if r.notify != nil {
+		chNotifyReady = make(chan struct{})
+		go r.watchNotify(ctx, pid, chNotifyReady, stop)
+	}
+	if r.Type == TypeNotify {
+		r.logger.Printf("单元处于 starting 状态，等待进程通知 READY=1")
+	} else if r.Readiness != nil {
+		r.logger.Printf("单元处于 starting 状态，等待就绪检查通过")
+	}
+	go func() {
+		if r.Type == TypeNotify {
+			select {
+			case <-chNotifyReady:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if r.Readiness != nil {
+			if !r.Readiness.waitReady(ctx, r.logger) {
+				return
+			}
+			r.logger.Printf("就绪检查通过")
+		}
+		markUnitReady(r.Name)
+
+		if r.Liveness != nil {
+			if !r.Liveness.watchAlive(ctx, r.logger) {
+				r.logger.Errorf("存活检查连续失败 %d 次，停止进程", r.Liveness.FailureThreshold)
+				stop("存活检查失败")
+			}
+		}
+	}()
+}
+
+// drainNotify 丢弃上一个进程遗留的 sd_notify 消息
+func (r *DaemonRunner) drainNotify() {
+	for {
+		select {
+		case <-r.chNotify:
+		default:
+			return
+		}
+	}
+}
+
+// watchNotify 处理进程组 pid 内的进程通过 sd_notify 发送的消息，收到 READY=1 后关闭 ready，看门狗超时后调用 stop 停止进程
+func (r *DaemonRunner) watchNotify(ctx context.Context, pid int, ready chan struct{}, stop func(reason string)) {
+	var (
+		watchdog = r.Watchdog
+		timer    *time.Timer
+		chTimer  <-chan time.Time
+		isReady  bool
+	)
+	resetWatchdog := func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		chTimer = nil
+		if watchdog > 0 {
+			timer = time.NewTimer(watchdog)
+			chTimer = timer.C
+		}
+	}
+	resetWatchdog()
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-chTimer:
+			r.logger.Errorf("看门狗在 %s 内未收到 WATCHDOG=1，停止进程", watchdog)
+			stop("看门狗超时")
+			return
+		case msg := <-r.chNotify:
+			// 抽象命名空间的地址可以被同一网络命名空间内的任意进程连接，只接受单元进程组内的进程发送的消息
+			if msg.PGID >= 0 && msg.PGID != pid {
+				r.logger.Errorf("忽略不是由单元进程组发送的 sd_notify 消息: %s=%s", msg.Key, msg.Value)
+				continue
+			}
+			switch msg.Key {
+			case "READY":
+				if msg.Value == "1" && !isReady {
+					isReady = true
+					r.logger.Printf("进程通知就绪")
+					close(ready)
+				}
+			case "STATUS":
+				r.logger.Printf("进程状态: %s", msg.Value)
+			case "MAINPID":
+				r.logger.Printf("进程通知主进程为 %s，不支持切换主进程，仍然跟踪原进程", msg.Value)
+			case "STOPPING":
+				if msg.Value == "1" {
+					r.logger.Printf("进程通知正在停止")
+				}
+			case "WATCHDOG":
+				switch msg.Value {
+				case "1":
+					resetWatchdog()
+				case "trigger":
+					r.logger.Errorf("进程主动触发看门狗，停止进程")
+					stop("看门狗触发")
+					return
+				}
+			case "WATCHDOG_USEC":
+				usec, err := strconv.ParseInt(msg.Value, 10, 64)
+				if err != nil || usec <= 0 {
+					r.logger.Errorf("无效的 WATCHDOG_USEC=%s", msg.Value)
+					continue
+				}
+				watchdog = time.Duration(usec) * time.Microsecond
+				r.logger.Printf("进程设置看门狗超时时间为 %s", watchdog)
+				resetWatchdog()
+			}
+		}
+	}
 }
 
 func (r *DaemonRunner) Run(ctx context.Context) {
 	r.logger.Printf("控制器启动")
 	defer r.logger.Printf("控制器退出")
+
+	if r.notify != nil {
+		defer r.notify.Close()
+		go r.notify.Serve(r.chNotify)
+	}
+	defer closeFiles(r.sockets)
+
+	// 首次启动前等待
+	if delay := r.StartDelay + r.staggerDelay(); delay > 0 {
+		r.logger.Printf("%s 后启动", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
 forLoop:
 	for {
 		// 检查 ctx 是否已经结束
@@ -24,23 +263,110 @@ forLoop:
 			break forLoop
 		}
 
+		startedAt := time.Now()
+
+		// runCtx 在进程退出，或者需要停止进程时结束
+		runCtx, runCancel := context.WithCancel(ctx)
+		chReason := make(chan string, 1)
+
+		opts := r.ExecuteOptions
+		if r.notify != nil {
+			r.drainNotify()
+			opts.extraEnv = append(opts.extraEnv, "NOTIFY_SOCKET="+r.notify.addr)
+			if r.Watchdog > 0 {
+				opts.extraEnv = append(opts.extraEnv, "WATCHDOG_USEC="+strconv.FormatInt(int64(r.Watchdog/time.Microsecond), 10))
+			}
+		}
+		if len(r.sockets) > 0 {
+			names := make([]string, 0, len(r.sockets))
+			for range r.sockets {
+				names = append(names, r.Name)
+			}
+			opts.extraFiles = append(opts.extraFiles, r.sockets...)
+			opts.extraEnv = append(opts.extraEnv, "LISTEN_FDS="+strconv.Itoa(len(r.sockets)), "LISTEN_FDNAMES="+strings.Join(names, ":"))
+			opts.helper.ListenPID = true
+		}
+		opts.onStart = func(pid int) {
+			r.onStart(runCtx, pid, func(reason string) {
+				select {
+				case chReason <- reason:
+				default:
+				}
+				runCancel()
+			})
+		}
+
 		var err error
-		if err = execute(r.ExecuteOptions, r.logger); err != nil {
-			r.logger.Errorf("启动失败: %s", err.Error())
+		if err = execute(runCtx, opts, r.logger); err != nil {
+			if _, ok := exitCode(err); !ok {
+				r.logger.Errorf("启动失败: %s", err.Error())
+			}
 		}
 
+		runCancel()
+
+		var reason string
+		select {
+		case reason = <-chReason:
+		default:
+		}
+
+		uptime := time.Since(startedAt)
+
 		// 检查 ctx 是否已经结束
 		if ctx.Err() != nil {
 			break forLoop
 		}
 
+		// 主单元退出后，关闭 minit
+		if r.Main {
+			code, ok := exitCode(err)
+			if !ok {
+				code = 1
+			}
+			requestShutdown(shutdownRequest{
+				name:   r.Name,
+				code:   code,
+				reason: fmt.Sprintf("主单元退出，退出码 %d", code),
+			})
+			break forLoop
+		}
+
+		// 检查重启策略
+		if !r.shouldRestart(err, reason) {
+			r.logger.Printf("重启策略为 %s，不再重启", r.Restart)
+			if r.Critical && r.isFailure(err, reason) {
+				if reason != "" {
+					requestCriticalShutdown(r.Name, fmt.Sprintf("由于%s，进程被停止", reason))
+				} else {
+					requestCriticalShutdown(r.Name, fmt.Sprintf("进程异常退出: %s", err.Error()))
+				}
+			}
+			break forLoop
+		}
+
+		// 检查重启次数
+		if !r.checkStartLimit(time.Now()) {
+			r.logger.Errorf("单元进入 failed 状态: %s 内重启次数达到 start_limit=%d，不再重启", r.StartLimitInterval, r.StartLimit)
+			if r.Critical {
+				requestCriticalShutdown(r.Name, fmt.Sprintf("%s 内重启次数达到 start_limit=%d", r.StartLimitInterval, r.StartLimit))
+			}
+			break forLoop
+		}
+
 		// 重试
-		r.logger.Printf("5s 后重启")
+		delay := r.backoff.next(uptime) + r.staggerDelay()
+		if reason != "" {
+			r.logger.Printf("进程运行了 %s，由于%s，%s 后重启", uptime.Round(time.Millisecond), reason, delay.Round(time.Millisecond))
+		} else {
+			r.logger.Printf("进程运行了 %s，%s 后重启", uptime.Round(time.Millisecond), delay.Round(time.Millisecond))
+		}
 
-		timer := time.NewTimer(time.Second * 5)
+		timer := time.NewTimer(delay)
 		select {
 		case <-timer.C:
 		case <-ctx.Done():
+			timer.Stop()
 			break forLoop
 		}
 	}
@@ -50,8 +376,101 @@ func NewDaemonRunner(unit Unit, logger *mlog.Logger) (Runner, error) {
 	if len(unit.Command) == 0 {
 		return nil, fmt.Errorf("没有指定命令，检查 command 字段")
 	}
+	switch unit.Restart {
+	case "":
+		unit.Restart = RestartAlways
+	case RestartAlways, RestartOnFailure, RestartNever:
+	default:
+		return nil, fmt.Errorf("未知的重启策略 %s，检查 restart 字段", unit.Restart)
+	}
+	if unit.RestartDelay <= 0 {
+		unit.RestartDelay = DefaultRestartDelay
+	}
+	if unit.RestartDelayMax <= 0 {
+		unit.RestartDelayMax = DefaultRestartDelayMax
+	}
+	if unit.RestartDelayMax < unit.RestartDelay {
+		unit.RestartDelayMax = unit.RestartDelay
+	}
+	if unit.RestartMultiplier == 0 {
+		unit.RestartMultiplier = DefaultRestartMultiplier
+	}
+	if unit.RestartMultiplier < 1 {
+		return nil, fmt.Errorf("restart_multiplier 不能小于 1")
+	}
+	if unit.RestartJitter < 0 || unit.RestartJitter > 1 {
+		return nil, fmt.Errorf("restart_jitter 必须在 0 到 1 之间")
+	}
+	if unit.RestartReset <= 0 {
+		unit.RestartReset = DefaultRestartReset
+	}
+	if unit.StartLimitInterval <= 0 {
+		unit.StartLimitInterval = DefaultStartLimitInterval
+	}
+	if unit.Readiness != nil {
+		probe := *unit.Readiness
+		if err := probe.validate(); err != nil {
+			return nil, fmt.Errorf("就绪检查配置错误，检查 readiness 字段: %s", err.Error())
+		}
+		unit.Readiness = &probe
+	}
+	if unit.Liveness != nil {
+		probe := *unit.Liveness
+		if err := probe.validate(); err != nil {
+			return nil, fmt.Errorf("存活检查配置错误，检查 liveness 字段: %s", err.Error())
+		}
+		unit.Liveness = &probe
+	}
+	switch unit.Type {
+	case "":
+		unit.Type = TypeSimple
+	case TypeSimple, TypeNotify:
+	default:
+		return nil, fmt.Errorf("未知的进程类型 %s，检查 type 字段", unit.Type)
+	}
+	if unit.StartDelay < 0 {
+		return nil, fmt.Errorf("start_delay 不能小于 0")
+	}
+	if unit.ReplicaStagger < 0 {
+		return nil, fmt.Errorf("replica_stagger 不能小于 0")
+	}
+	if unit.Watchdog < 0 {
+		return nil, fmt.Errorf("watchdog 不能小于 0")
+	}
+	if len(unit.Sockets) > 0 && unit.Count > 1 {
+		return nil, fmt.Errorf("同一个地址只能监听一次，sockets 不能与 count 同时使用")
+	}
+	var sockets []*os.File
+	for _, address := range unit.Sockets {
+		file, err := listenSocket(strings.TrimSpace(address))
+		if err != nil {
+			closeFiles(sockets)
+			return nil, fmt.Errorf("无法监听套接字 %s，检查 sockets 字段: %s", address, err.Error())
+		}
+		logger.Printf("监听套接字 %s", address)
+		sockets = append(sockets, file)
+	}
+	var notify *notifySocket
+	if unit.Type == TypeNotify || unit.Watchdog > 0 {
+		var err error
+		if notify, err = newNotifySocket(unit.Name); err != nil {
+			closeFiles(sockets)
+			return nil, fmt.Errorf("无法创建 sd_notify 套接字: %s", err.Error())
+		}
+	}
 	return &DaemonRunner{
-		Unit:   unit,
-		logger: logger,
+		Unit:     unit,
+		logger:   logger,
+		notify:   notify,
+		chNotify: make(chan notifyMessage, 16),
+		sockets:  sockets,
+		backoff: &restartBackoff{
+			initial:    unit.RestartDelay,
+			max:        unit.RestartDelayMax,
+			multiplier: unit.RestartMultiplier,
+			jitter:     unit.RestartJitter,
+			reset:      unit.RestartReset,
+			rnd:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		},
 	}, nil
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestDaemonRunnerShouldRestart(t *testing.T) {
+	errExit3 := exec.Command("sh", "-c", "exit 3").Run()
+	_, ok := exitCode(errExit3)
+	require.True(t, ok)
+
+	r := &DaemonRunner{Unit: Unit{Restart: RestartAlways}}
+	require.True(t, r.shouldRestart(nil, ""))
+	require.True(t, r.shouldRestart(errExit3, ""))
+
+	r = &DaemonRunner{Unit: Unit{Restart: RestartNever}}
+	require.False(t, r.shouldRestart(nil, ""))
+	require.False(t, r.shouldRestart(errExit3, ""))
+
+	r = &DaemonRunner{Unit: Unit{Restart: RestartOnFailure}}
+	require.False(t, r.shouldRestart(nil, ""))
+	require.True(t, r.shouldRestart(errExit3, ""))
+
+	r.SuccessExitCodes = []int{3}
+	require.False(t, r.shouldRestart(errExit3, ""))
+}
+
+func TestDaemonRunnerStoppedWithReason(t *testing.T) {
+	// 进程收到 SIGTERM 后正常退出，但是由于存活检查或者看门狗被停止，仍然视为失败
+	r := &DaemonRunner{Unit: Unit{Restart: RestartOnFailure, Critical: true}}
+	require.False(t, r.isFailure(nil, ""))
+	require.True(t, r.isFailure(nil, "存活检查连续失败 3 次"))
+	require.True(t, r.shouldRestart(nil, "存活检查连续失败 3 次"))
+	require.True(t, r.shouldRestart(nil, "看门狗超时"))
+	require.True(t, r.shouldRestart(nil, "看门狗触发"))
+
+	r = &DaemonRunner{Unit: Unit{Restart: RestartNever}}
+	require.False(t, r.shouldRestart(nil, "看门狗超时"))
+}
+
+func TestRestartBackoff(t *testing.T) {
+	b := &restartBackoff{
+		initial:    time.Second,
+		max:        time.Second * 5,
+		multiplier: 2,
+		reset:      time.Minute,
+	}
+	require.Equal(t, time.Second, b.next(0))
+	require.Equal(t, time.Second*2, b.next(0))
+	require.Equal(t, time.Second*4, b.next(0))
+	require.Equal(t, time.Second*5, b.next(0))
+	require.Equal(t, time.Second*5, b.next(time.Second*30))
+	require.Equal(t, time.Second, b.next(time.Minute))
+
+	b = &restartBackoff{
+		initial:    time.Second * 10,
+		max:        time.Second * 10,
+		multiplier: 1,
+		jitter:     0.5,
+		reset:      time.Minute,
+		rnd:        rand.New(rand.NewSource(1)),
+	}
+	for i := 0; i < 100; i++ {
+		d := b.next(0)
+		require.True(t, d >= time.Second*5 && d <= time.Second*15)
+	}
+}
+
+func TestDaemonRunnerCheckStartLimit(t *testing.T) {
+	r := &DaemonRunner{Unit: Unit{StartLimit: 2, StartLimitInterval: time.Minute}}
+	now := time.Now()
+	require.True(t, r.checkStartLimit(now))
+	require.True(t, r.checkStartLimit(now.Add(time.Second)))
+	require.False(t, r.checkStartLimit(now.Add(time.Second*2)))
+	require.True(t, r.checkStartLimit(now.Add(time.Minute+time.Second)))
+}
+
+func TestDaemonRunnerStaggerDelay(t *testing.T) {
+	r := &DaemonRunner{Unit: Unit{ReplicaStagger: time.Second * 2}}
+	require.Equal(t, time.Duration(0), r.staggerDelay())
+	r.replica = 3
+	require.Equal(t, time.Second*6, r.staggerDelay())
+}
+
+func TestNewDaemonRunnerSocketsWithCount(t *testing.T) {
+	unit := Unit{Name: "app-1", Count: 2, Sockets: []string{"tcp://127.0.0.1:0"}}
+	unit.Command = []string{"sleep", "1"}
+	_, err := NewDaemonRunner(unit, nil)
+	require.Error(t, err)
+}
+
+func TestDaemonRunnerWatchNotifySender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minit-notify")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logger := newTestLogger(t, dir)
+	defer logger.Close()
+
+	r := &DaemonRunner{logger: logger, chNotify: make(chan notifyMessage, 16)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ready := make(chan struct{})
+	go r.watchNotify(ctx, 100, ready, func(string) {})
+
+	// 不是由单元进程组发送的消息被忽略
+	r.chNotify <- notifyMessage{Key: "READY", Value: "1", PGID: 200}
+	r.chNotify <- notifyMessage{Key: "READY", Value: "1", PGID: 0}
+	select {
+	case <-ready:
+		t.Fatal("unexpected ready")
+	case <-time.After(time.Millisecond * 100):
+	}
+	r.chNotify <- notifyMessage{Key: "READY", Value: "1", PGID: 100}
+	select {
+	case <-ready:
+	case <-time.After(time.Second * 3):
+		t.Fatal("timeout")
+	}
+}
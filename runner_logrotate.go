@@ -76,11 +76,13 @@ func (l *LogrotateRunner) Run(ctx context.Context) {
 	l.logger.Printf("控制器启动")
 	defer l.logger.Printf("控制器退出")
 
+	markUnitReady(l.Name)
+
 	cr := cron.New(cron.WithLogger(cron.PrintfLogger(l.logger)))
 	_, err := cr.AddFunc(RotationCron, func() {
 		l.logger.Printf("开始日志轮转")
 		defer l.logger.Printf("结束日志轮转")
-		l.rotate()
+		l.rotate(ctx)
 	})
 	if err != nil {
 		panic(err)
@@ -119,7 +121,7 @@ func (l *LogrotateRunner) collectRotationFiles() []*rotationFile {
 	return ret
 }
 
-func (l *LogrotateRunner) rotate() {
+func (l *LogrotateRunner) rotate(ctx context.Context) {
 	now := time.Now()
 	bod := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
 	boy := bod.Add(-time.Hour * 24)
@@ -204,7 +206,7 @@ func (l *LogrotateRunner) rotate() {
 	}
 
 	if len(l.Command) > 0 {
-		_ = execute(l.ExecuteOptions, l.logger)
+		_ = execute(ctx, l.ExecuteOptions, l.logger)
 	}
 }
 
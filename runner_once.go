@@ -16,9 +16,14 @@ type OnceRunner struct {
 func (r *OnceRunner) Run(ctx context.Context) {
 	r.logger.Printf("控制器启动")
 	defer r.logger.Printf("控制器退出")
-	if err := execute(r.ExecuteOptions, r.logger); err != nil {
-		r.logger.Errorf("启动失败: %s", err.Error())
-		return
+	err := execute(ctx, r.ExecuteOptions, r.logger)
+	if err != nil {
+		if _, ok := exitCode(err); !ok {
+			r.logger.Errorf("启动失败: %s", err.Error())
+		}
+	}
+	if r.Critical && !r.IsSuccess(err) {
+		requestCriticalShutdown(r.Name, err.Error())
 	}
 }
 
@@ -3,12 +3,277 @@
 package main
 
 import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"net"
+	"os"
 	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
 	"syscall"
 )
 
+var (
+	knownCapabilityNames = map[string]int{
+		"CHOWN":              unix.CAP_CHOWN,
+		"DAC_OVERRIDE":       unix.CAP_DAC_OVERRIDE,
+		"DAC_READ_SEARCH":    unix.CAP_DAC_READ_SEARCH,
+		"FOWNER":             unix.CAP_FOWNER,
+		"FSETID":             unix.CAP_FSETID,
+		"KILL":               unix.CAP_KILL,
+		"SETGID":             unix.CAP_SETGID,
+		"SETUID":             unix.CAP_SETUID,
+		"SETPCAP":            unix.CAP_SETPCAP,
+		"LINUX_IMMUTABLE":    unix.CAP_LINUX_IMMUTABLE,
+		"NET_BIND_SERVICE":   unix.CAP_NET_BIND_SERVICE,
+		"NET_BROADCAST":      unix.CAP_NET_BROADCAST,
+		"NET_ADMIN":          unix.CAP_NET_ADMIN,
+		"NET_RAW":            unix.CAP_NET_RAW,
+		"IPC_LOCK":           unix.CAP_IPC_LOCK,
+		"IPC_OWNER":          unix.CAP_IPC_OWNER,
+		"SYS_MODULE":         unix.CAP_SYS_MODULE,
+		"SYS_RAWIO":          unix.CAP_SYS_RAWIO,
+		"SYS_CHROOT":         unix.CAP_SYS_CHROOT,
+		"SYS_PTRACE":         unix.CAP_SYS_PTRACE,
+		"SYS_PACCT":          unix.CAP_SYS_PACCT,
+		"SYS_ADMIN":          unix.CAP_SYS_ADMIN,
+		"SYS_BOOT":           unix.CAP_SYS_BOOT,
+		"SYS_NICE":           unix.CAP_SYS_NICE,
+		"SYS_RESOURCE":       unix.CAP_SYS_RESOURCE,
+		"SYS_TIME":           unix.CAP_SYS_TIME,
+		"SYS_TTY_CONFIG":     unix.CAP_SYS_TTY_CONFIG,
+		"MKNOD":              unix.CAP_MKNOD,
+		"LEASE":              unix.CAP_LEASE,
+		"AUDIT_WRITE":        unix.CAP_AUDIT_WRITE,
+		"AUDIT_CONTROL":      unix.CAP_AUDIT_CONTROL,
+		"SETFCAP":            unix.CAP_SETFCAP,
+		"MAC_OVERRIDE":       unix.CAP_MAC_OVERRIDE,
+		"MAC_ADMIN":          unix.CAP_MAC_ADMIN,
+		"SYSLOG":             unix.CAP_SYSLOG,
+		"WAKE_ALARM":         unix.CAP_WAKE_ALARM,
+		"BLOCK_SUSPEND":      unix.CAP_BLOCK_SUSPEND,
+		"AUDIT_READ":         unix.CAP_AUDIT_READ,
+		"PERFMON":            unix.CAP_PERFMON,
+		"BPF":                unix.CAP_BPF,
+		"CHECKPOINT_RESTORE": unix.CAP_CHECKPOINT_RESTORE,
+	}
+)
+
 func setupCmdSysProcAttr(cmd *exec.Cmd) {
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true,
 	}
 }
+
+// setupCmdTTY 使用伪终端时，进程需要创建新的会话，并将 fd 对应的伪终端设置为控制终端；会话首进程同时也是进程组首进程
+func setupCmdTTY(cmd *exec.Cmd, fd int) {
+	cmd.SysProcAttr.Setpgid = false
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = fd
+}
+
+// setupCmdCredential 设置运行进程的用户和组
+func setupCmdCredential(cmd *exec.Cmd, cred *credential) error {
+	cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid:    cred.Uid,
+		Gid:    cred.Gid,
+		Groups: cred.Groups,
+	}
+	return nil
+}
+
+// signalProcess 向进程发送信号，group 为 true 时发送给整个进程组
+func signalProcess(pid int, sig syscall.Signal, group bool) error {
+	if group {
+		return syscall.Kill(-pid, sig)
+	}
+	return syscall.Kill(pid, sig)
+}
+
+// notifySocketAddress 返回单元的 sd_notify 套接字地址，使用抽象命名空间，不占用文件系统
+func notifySocketAddress(name string) string {
+	return fmt.Sprintf("@minit/notify/%d/%s", os.Getpid(), name)
+}
+
+// setupNotifyConn 为 sd_notify 套接字启用 SO_PASSCRED，接收消息时附带发送者的进程号
+func setupNotifyConn(conn *net.UnixConn) (err error) {
+	var raw syscall.RawConn
+	if raw, err = conn.SyscallConn(); err != nil {
+		return
+	}
+	if err1 := raw.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_PASSCRED, 1)
+	}); err1 != nil {
+		err = err1
+	}
+	return
+}
+
+// readNotifyConn 读取一条 sd_notify 消息，pid 为发送者的进程号，无法获取时为 0
+func readNotifyConn(conn *net.UnixConn, buf []byte) (n int, pid int, err error) {
+	oob := make([]byte, unix.CmsgSpace(unix.SizeofUcred))
+	var oobn int
+	if n, oobn, _, _, err = conn.ReadMsgUnix(buf, oob); err != nil {
+		return
+	}
+	msgs, err1 := unix.ParseSocketControlMessage(oob[:oobn])
+	if err1 != nil {
+		return
+	}
+	for i := range msgs {
+		if cred, err1 := unix.ParseUnixCredentials(&msgs[i]); err1 == nil {
+			pid = int(cred.Pid)
+		}
+	}
+	return
+}
+
+// notifySenderGroup 返回 sd_notify 消息发送者所属的进程组，无法获取时返回 0
+func notifySenderGroup(pid int) int {
+	if pid <= 0 {
+		return 0
+	}
+	pgid, err := unix.Getpgid(pid)
+	if err != nil {
+		return 0
+	}
+	return pgid
+}
+
+// helperExecutable 返回辅助进程的可执行文件，即 minit 自身
+func helperExecutable() (string, error) {
+	return "/proc/self/exe", nil
+}
+
+// parseCapabilities 解析能力名称列表，支持 CAP_NET_BIND_SERVICE 或者 net_bind_service，返回排序后的能力编号
+func parseCapabilities(names []string) (caps []int, err error) {
+	caps = make([]int, 0, len(names))
+	seen := map[int]bool{}
+	for _, name := range names {
+		key := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "CAP_")
+		c, ok := knownCapabilityNames[key]
+		if !ok {
+			err = fmt.Errorf("未知的能力 %s", name)
+			return
+		}
+		if !seen[c] {
+			seen[c] = true
+			caps = append(caps, c)
+		}
+	}
+	sort.Ints(caps)
+	return
+}
+
+// capabilityName 返回能力编号对应的名称
+func capabilityName(c int) string {
+	for name, val := range knownCapabilityNames {
+		if val == c {
+			return "CAP_" + name
+		}
+	}
+	return strconv.Itoa(c)
+}
+
+// resolveCapabilities 检查 capabilities, ambient_capabilities 和 no_new_privs 字段
+func resolveCapabilities(opts ExecuteOptions) (caps helperCapabilities, err error) {
+	caps.NoNewPrivs = opts.NoNewPrivs
+	// capabilities 为空列表时表示移除全部能力，因此区分 nil 和空列表
+	if opts.Capabilities != nil {
+		var bounding []int
+		if bounding, err = parseCapabilities(opts.Capabilities); err != nil {
+			return
+		}
+		caps.Bounding = &bounding
+	}
+	if len(opts.AmbientCapabilities) > 0 {
+		if caps.Ambient, err = parseCapabilities(opts.AmbientCapabilities); err != nil {
+			return
+		}
+		if caps.Bounding != nil {
+			keep := map[int]bool{}
+			for _, c := range *caps.Bounding {
+				keep[c] = true
+			}
+			for _, c := range caps.Ambient {
+				if !keep[c] {
+					err = fmt.Errorf("ambient_capabilities 中的 %s 未包含在 capabilities 中", capabilityName(c))
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// lastCapability 返回内核支持的最大能力编号
+func lastCapability() int {
+	if buf, err := ioutil.ReadFile("/proc/sys/kernel/cap_last_cap"); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(buf))); err == nil {
+			return n
+		}
+	}
+	return unix.CAP_LAST_CAP
+}
+
+// setupCapabilitiesBeforeCredential 在切换用户之前，从 bounding set 中移除能力，并保证切换用户后能力不会被清空
+func setupCapabilitiesBeforeCredential(caps helperCapabilities) (err error) {
+	if caps.Bounding != nil {
+		keep := map[int]bool{}
+		for _, c := range *caps.Bounding {
+			keep[c] = true
+		}
+		for c := 0; c <= lastCapability(); c++ {
+			if keep[c] {
+				continue
+			}
+			if err = unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil {
+				err = fmt.Errorf("无法从 bounding set 中移除 %s: %s", capabilityName(c), err.Error())
+				return
+			}
+		}
+	}
+	if len(caps.Ambient) > 0 {
+		if err = unix.Prctl(unix.PR_SET_KEEPCAPS, 1, 0, 0, 0); err != nil {
+			err = fmt.Errorf("无法设置 PR_SET_KEEPCAPS: %s", err.Error())
+			return
+		}
+	}
+	return
+}
+
+// setupCapabilitiesAfterCredential 在切换用户之后，设置 ambient 能力和 no_new_privs
+func setupCapabilitiesAfterCredential(caps helperCapabilities) (err error) {
+	if len(caps.Ambient) > 0 {
+		// ambient 能力必须同时存在于 permitted 和 inheritable 中
+		hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+		var data [2]unix.CapUserData
+		if err = unix.Capget(&hdr, &data[0]); err != nil {
+			err = fmt.Errorf("无法获取能力: %s", err.Error())
+			return
+		}
+		for _, c := range caps.Ambient {
+			data[c/32].Inheritable |= 1 << uint(c%32)
+		}
+		if err = unix.Capset(&hdr, &data[0]); err != nil {
+			err = fmt.Errorf("无法设置 inheritable 能力: %s", err.Error())
+			return
+		}
+		for _, c := range caps.Ambient {
+			if err = unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_RAISE, uintptr(c), 0, 0); err != nil {
+				err = fmt.Errorf("无法设置 ambient 能力 %s: %s", capabilityName(c), err.Error())
+				return
+			}
+		}
+	}
+	if caps.NoNewPrivs {
+		if err = unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			err = fmt.Errorf("无法设置 no_new_privs: %s", err.Error())
+			return
+		}
+	}
+	return
+}
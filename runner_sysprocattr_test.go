@@ -0,0 +1,42 @@
+//+build linux
+
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+	"testing"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	caps, err := parseCapabilities([]string{"net_raw", "CAP_NET_BIND_SERVICE", " NET_RAW "})
+	require.NoError(t, err)
+	require.Equal(t, []int{unix.CAP_NET_BIND_SERVICE, unix.CAP_NET_RAW}, caps)
+	_, err = parseCapabilities([]string{"CAP_UNKNOWN"})
+	require.Error(t, err)
+}
+
+func TestResolveCapabilities(t *testing.T) {
+	caps, err := resolveCapabilities(ExecuteOptions{})
+	require.NoError(t, err)
+	require.True(t, caps.empty())
+
+	caps, err = resolveCapabilities(ExecuteOptions{Capabilities: []string{}, NoNewPrivs: true})
+	require.NoError(t, err)
+	require.NotNil(t, caps.Bounding)
+	require.Empty(t, *caps.Bounding)
+	require.True(t, caps.NoNewPrivs)
+
+	caps, err = resolveCapabilities(ExecuteOptions{
+		Capabilities:        []string{"CAP_NET_BIND_SERVICE"},
+		AmbientCapabilities: []string{"NET_BIND_SERVICE"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int{unix.CAP_NET_BIND_SERVICE}, caps.Ambient)
+
+	_, err = resolveCapabilities(ExecuteOptions{
+		Capabilities:        []string{"CAP_NET_BIND_SERVICE"},
+		AmbientCapabilities: []string{"CAP_NET_RAW"},
+	})
+	require.Error(t, err)
+}
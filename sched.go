@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	IONiceClassRealtime   = "realtime"
+	IONiceClassBestEffort = "best-effort"
+	IONiceClassIdle       = "idle"
+)
+
+var (
+	knownIONiceClasses = map[string]int{
+		IONiceClassRealtime:   1,
+		IONiceClassBestEffort: 2,
+		IONiceClassIdle:       3,
+	}
+)
+
+// schedOptions 进程启动后设置的调度参数
+type schedOptions struct {
+	Nice        *int
+	IONiceClass int // 0 表示不设置
+	IONiceLevel int
+	OOMScoreAdj *int
+	CPUs        []int
+}
+
+func (s schedOptions) empty() bool {
+	return s.Nice == nil && s.IONiceClass == 0 && s.OOMScoreAdj == nil && len(s.CPUs) == 0
+}
+
+// schedSettings 检查 nice, ionice_class, ionice_level, oom_score_adj 和 cpu_affinity 字段
+func (o ExecuteOptions) schedSettings() (s schedOptions, err error) {
+	if o.Nice != nil {
+		if *o.Nice < -20 || *o.Nice > 19 {
+			err = fmt.Errorf("nice 必须在 -20 到 19 之间")
+			return
+		}
+		s.Nice = o.Nice
+	}
+	if o.IONiceClass != "" {
+		var ok bool
+		if s.IONiceClass, ok = knownIONiceClasses[strings.ToLower(strings.TrimSpace(o.IONiceClass))]; !ok {
+			err = fmt.Errorf("未知的 ionice_class %s，可选值为 realtime, best-effort, idle", o.IONiceClass)
+			return
+		}
+		// 与 ionice 命令保持一致，默认为 4
+		s.IONiceLevel = 4
+		if o.IONiceLevel != nil {
+			if *o.IONiceLevel < 0 || *o.IONiceLevel > 7 {
+				err = fmt.Errorf("ionice_level 必须在 0 到 7 之间")
+				return
+			}
+			s.IONiceLevel = *o.IONiceLevel
+		}
+		if s.IONiceClass == knownIONiceClasses[IONiceClassIdle] {
+			s.IONiceLevel = 0
+		}
+	} else if o.IONiceLevel != nil {
+		err = fmt.Errorf("设置 ionice_level 时必须同时设置 ionice_class")
+		return
+	}
+	if o.OOMScoreAdj != nil {
+		if *o.OOMScoreAdj < -1000 || *o.OOMScoreAdj > 1000 {
+			err = fmt.Errorf("oom_score_adj 必须在 -1000 到 1000 之间")
+			return
+		}
+		s.OOMScoreAdj = o.OOMScoreAdj
+	}
+	if o.CPUAffinity != "" {
+		if s.CPUs, err = parseCPUList(o.CPUAffinity); err != nil {
+			err = fmt.Errorf("无效的 cpu_affinity: %s", err.Error())
+			return
+		}
+	}
+	return
+}
+
+// parseCPUList 解析 0-3,6 格式的 CPU 列表
+func parseCPUList(s string) (cpus []int, err error) {
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		splits := strings.SplitN(item, "-", 2)
+		var start, end int
+		if start, err = strconv.Atoi(strings.TrimSpace(splits[0])); err != nil || start < 0 {
+			err = fmt.Errorf("无法解析 %s", item)
+			return
+		}
+		end = start
+		if len(splits) == 2 {
+			if end, err = strconv.Atoi(strings.TrimSpace(splits[1])); err != nil || end < start {
+				err = fmt.Errorf("无法解析 %s", item)
+				return
+			}
+		}
+		for cpu := start; cpu <= end; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	if len(cpus) == 0 {
+		err = fmt.Errorf("CPU 列表为空")
+	}
+	return
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSchedSettings(t *testing.T) {
+	nice, level, adj := 10, 7, 500
+	s, err := ExecuteOptions{
+		Nice:        &nice,
+		IONiceClass: "best-effort",
+		IONiceLevel: &level,
+		OOMScoreAdj: &adj,
+		CPUAffinity: "0-2, 5",
+	}.schedSettings()
+	require.NoError(t, err)
+	require.Equal(t, 10, *s.Nice)
+	require.Equal(t, 2, s.IONiceClass)
+	require.Equal(t, 7, s.IONiceLevel)
+	require.Equal(t, 500, *s.OOMScoreAdj)
+	require.Equal(t, []int{0, 1, 2, 5}, s.CPUs)
+	require.False(t, s.empty())
+
+	s, err = ExecuteOptions{IONiceClass: "idle"}.schedSettings()
+	require.NoError(t, err)
+	require.Equal(t, 3, s.IONiceClass)
+	require.Equal(t, 0, s.IONiceLevel)
+
+	s, err = ExecuteOptions{IONiceClass: "realtime"}.schedSettings()
+	require.NoError(t, err)
+	require.Equal(t, 4, s.IONiceLevel)
+
+	s, err = ExecuteOptions{}.schedSettings()
+	require.NoError(t, err)
+	require.True(t, s.empty())
+
+	nice, level, adj = 20, 8, 1001
+	for _, opts := range []ExecuteOptions{
+		{Nice: &nice},
+		{IONiceClass: "fast"},
+		{IONiceClass: "realtime", IONiceLevel: &level},
+		{IONiceLevel: &level},
+		{OOMScoreAdj: &adj},
+		{CPUAffinity: "3-1"},
+		{CPUAffinity: ","},
+	} {
+		_, err = opts.schedSettings()
+		require.Error(t, err)
+	}
+}
@@ -0,0 +1,110 @@
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	cgroupMountPoint = "/sys/fs/cgroup"
+	cgroupLeafName   = "minit"
+)
+
+var (
+	// cgroupDir minit 所在的 cgroup v2 目录，为空表示不可用
+	cgroupDir string
+)
+
+// setupCgroup 检查 cgroup v2 是否可用，将 minit 移动到叶子节点，并为子节点启用控制器
+func setupCgroup() (err error) {
+	if _, err = os.Stat(filepath.Join(cgroupMountPoint, "cgroup.controllers")); err != nil {
+		log.Errorf("未检测到 cgroup v2，忽略 memory_max, memory_high, cpu_max, pids_max 和 io_weight 字段")
+		err = nil
+		return
+	}
+
+	// 查找 minit 所在的 cgroup
+	var buf []byte
+	if buf, err = ioutil.ReadFile("/proc/self/cgroup"); err != nil {
+		return
+	}
+	var path string
+	for _, line := range strings.Split(string(buf), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			path = strings.TrimPrefix(line, "0::")
+			break
+		}
+	}
+	if path == "" {
+		log.Errorf("无法确定 minit 所在的 cgroup，忽略 cgroup 相关字段")
+		return
+	}
+	dir := filepath.Join(cgroupMountPoint, path)
+
+	// cgroup v2 中，启用了控制器的节点不能直接包含进程，将现有的进程移动到叶子节点
+	leaf := filepath.Join(dir, cgroupLeafName)
+	if err = os.MkdirAll(leaf, 0755); err != nil {
+		log.Errorf("无法创建 cgroup %s，忽略 cgroup 相关字段: %s", leaf, err.Error())
+		err = nil
+		return
+	}
+	if buf, err = ioutil.ReadFile(filepath.Join(dir, "cgroup.procs")); err != nil {
+		return
+	}
+	self := strconv.Itoa(os.Getpid())
+	for _, pid := range strings.Fields(string(buf)) {
+		// 其他进程可能已经退出，仅检查 minit 自身
+		if err1 := ioutil.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(pid), 0644); err1 != nil && pid == self {
+			log.Errorf("无法将 minit 移动到 cgroup %s，忽略 cgroup 相关字段: %s", leaf, err1.Error())
+			return
+		}
+	}
+
+	// 启用控制器
+	if buf, err = ioutil.ReadFile(filepath.Join(dir, "cgroup.controllers")); err != nil {
+		return
+	}
+	available := map[string]bool{}
+	for _, name := range strings.Fields(string(buf)) {
+		available[name] = true
+	}
+	for _, name := range []string{"cpu", "io", "memory", "pids"} {
+		if !available[name] {
+			log.Errorf("cgroup 控制器 %s 不可用", name)
+			continue
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+"+name), 0644); err != nil {
+			log.Errorf("无法启用 cgroup 控制器 %s: %s", name, err.Error())
+			err = nil
+		}
+	}
+
+	cgroupDir = dir
+	log.Printf("使用 cgroup v2: %s", dir)
+	return
+}
+
+// createUnitCgroup 创建单元的 cgroup 并写入配置，cgroup v2 不可用时返回 nil
+func createUnitCgroup(name string, settings []cgroupSetting) (cg *unitCgroup, err error) {
+	if cgroupDir == "" {
+		return
+	}
+	cg = &unitCgroup{dir: filepath.Join(cgroupDir, name)}
+	if err = os.MkdirAll(cg.dir, 0755); err != nil {
+		return
+	}
+	for _, setting := range settings {
+		if err = ioutil.WriteFile(filepath.Join(cg.dir, setting.File), []byte(setting.Value), 0644); err != nil {
+			err = fmt.Errorf("无法设置 %s=%s: %s", setting.File, setting.Value, err.Error())
+			cg.remove()
+			return
+		}
+	}
+	return
+}
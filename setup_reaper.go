@@ -0,0 +1,51 @@
+//+build linux
+
+package main
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func setupReaper() (err error) {
+	// 不是 PID 1 时，设置为子进程收割者，以便接管孤儿进程
+	if os.Getpid() != 1 {
+		if err = unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+			err = fmt.Errorf("无法设置 PR_SET_CHILD_SUBREAPER: %s", err.Error())
+			return
+		}
+		log.Printf("设置 PR_SET_CHILD_SUBREAPER")
+	}
+
+	chSig := make(chan os.Signal, 1)
+	signal.Notify(chSig, syscall.SIGCHLD)
+	go func() {
+		for range chSig {
+			reapZombies()
+		}
+	}()
+	return
+}
+
+// reapZombies 回收不是由 execute 启动的僵尸子进程，execute 启动的进程由 cmd.Wait 负责回收
+func reapZombies() {
+	childPidsLock.Lock()
+	defer childPidsLock.Unlock()
+
+	self := os.Getpid()
+	for _, st := range listProcStats() {
+		if st.PPid != self || st.State != 'Z' {
+			continue
+		}
+		if childPids[st.Pid] != nil {
+			continue
+		}
+		var status syscall.WaitStatus
+		if pid, err := syscall.Wait4(st.Pid, &status, syscall.WNOHANG, nil); err == nil && pid == st.Pid {
+			log.Printf("回收僵尸进程 %d(%s)", st.Pid, st.Comm)
+		}
+	}
+}
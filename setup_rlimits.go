@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"golang.org/x/sys/unix"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -53,6 +54,68 @@ func formatRLimitValue(v uint64) string {
 	}
 }
 
+// decodeRLimit 解析 soft:hard 或者 value 格式的资源限制，- 表示保持不变，unlimited 表示不限制
+func decodeRLimit(limit *syscall.Rlimit, val string) (err error) {
+	if strings.Contains(val, ":") {
+		splits := strings.Split(val, ":")
+		if len(splits) != 2 {
+			err = fmt.Errorf("格式应为 soft:hard")
+			return
+		}
+		if err = decodeRLimitValue(&limit.Cur, splits[0]); err != nil {
+			return
+		}
+		if err = decodeRLimitValue(&limit.Max, splits[1]); err != nil {
+			return
+		}
+	} else {
+		if err = decodeRLimitValue(&limit.Cur, val); err != nil {
+			return
+		}
+		limit.Max = limit.Cur
+	}
+	return
+}
+
+// resolveRLimits 解析单元的 rlimits 字段，未指定的部分使用 minit 当前的值
+func resolveRLimits(items map[string]string) (limits []helperRLimit, err error) {
+	names := make([]string, 0, len(items))
+	for name := range items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		val := strings.TrimSpace(items[name])
+		key := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "RLIMIT_")
+		res, ok := knownRLimitNames[key]
+		if !ok {
+			err = fmt.Errorf("未知的资源限制 %s", name)
+			return
+		}
+		var limit syscall.Rlimit
+		if err = syscall.Getrlimit(res, &limit); err != nil {
+			err = fmt.Errorf("无法获取 RLIMIT_%s: %s", key, err.Error())
+			return
+		}
+		if err = decodeRLimit(&limit, val); err != nil {
+			err = fmt.Errorf("无效的资源限制 %s=%s: %s", name, val, err.Error())
+			return
+		}
+		if limit.Cur > limit.Max {
+			err = fmt.Errorf("无效的资源限制 %s=%s: 软限制 %s 大于硬限制 %s", name, val, formatRLimitValue(limit.Cur), formatRLimitValue(limit.Max))
+			return
+		}
+		limits = append(limits, helperRLimit{
+			Name:     key,
+			Resource: res,
+			Cur:      limit.Cur,
+			Max:      limit.Max,
+		})
+	}
+	return
+}
+
 func setupRLimits() (err error) {
 	for name, res := range knownRLimitNames {
 		key := "MINIT_RLIMIT_" + name
@@ -66,25 +129,9 @@ func setupRLimits() (err error) {
 			return
 		}
 		log.Printf("获取 RLIMIT_%s=%s:%s", name, formatRLimitValue(limit.Cur), formatRLimitValue(limit.Max))
-		if strings.Contains(val, ":") {
-			splits := strings.Split(val, ":")
-			if len(splits) != 2 {
-				err = fmt.Errorf("无效的环境变量 %s=%s", key, val)
-				return
-			}
-			if err = decodeRLimitValue(&limit.Cur, splits[0]); err != nil {
-				err = fmt.Errorf("无效的环境变量 %s=%s: %s", key, val, err.Error())
-				return
-			}
-			if err = decodeRLimitValue(&limit.Max, splits[1]); err != nil {
-				err = fmt.Errorf("无效的环境变量 %s=%s: %s", key, val, err.Error())
-				return
-			}
-		} else {
-			if err = decodeRLimitValue(&limit.Cur, val); err != nil {
-				return
-			}
-			limit.Max = limit.Cur
+		if err = decodeRLimit(&limit, val); err != nil {
+			err = fmt.Errorf("无效的环境变量 %s=%s: %s", key, val, err.Error())
+			return
 		}
 		log.Printf("设置 RLIMIT_%s=%s:%s", name, formatRLimitValue(limit.Cur), formatRLimitValue(limit.Max))
 		if err = syscall.Setrlimit(res, &limit); err != nil {
@@ -0,0 +1,40 @@
+//+build linux
+
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+	"syscall"
+	"testing"
+)
+
+func TestDecodeRLimit(t *testing.T) {
+	limit := syscall.Rlimit{Cur: 1024, Max: 4096}
+	require.NoError(t, decodeRLimit(&limit, "-:unlimited"))
+	require.Equal(t, syscall.Rlimit{Cur: 1024, Max: unix.RLIM_INFINITY}, limit)
+	require.NoError(t, decodeRLimit(&limit, "2048"))
+	require.Equal(t, syscall.Rlimit{Cur: 2048, Max: 2048}, limit)
+	require.Error(t, decodeRLimit(&limit, "1:2:3"))
+	require.Error(t, decodeRLimit(&limit, "abc"))
+}
+
+func TestResolveRLimits(t *testing.T) {
+	var current syscall.Rlimit
+	require.NoError(t, syscall.Getrlimit(unix.RLIMIT_NOFILE, &current))
+
+	limits, err := resolveRLimits(map[string]string{
+		"nofile":       "64:-",
+		"RLIMIT_CORE": "0",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []helperRLimit{
+		{Name: "CORE", Resource: unix.RLIMIT_CORE, Cur: 0, Max: 0},
+		{Name: "NOFILE", Resource: unix.RLIMIT_NOFILE, Cur: 64, Max: current.Max},
+	}, limits)
+
+	_, err = resolveRLimits(map[string]string{"FOO": "1"})
+	require.Error(t, err)
+	_, err = resolveRLimits(map[string]string{"NOFILE": "100:10"})
+	require.Error(t, err)
+}
@@ -0,0 +1,47 @@
+//+build linux
+
+package main
+
+import "syscall"
+
+var (
+	knownSignalNames = map[string]syscall.Signal{
+		"ABRT":   syscall.SIGABRT,
+		"ALRM":   syscall.SIGALRM,
+		"CHLD":   syscall.SIGCHLD,
+		"CONT":   syscall.SIGCONT,
+		"HUP":    syscall.SIGHUP,
+		"INT":    syscall.SIGINT,
+		"IO":     syscall.SIGIO,
+		"KILL":   syscall.SIGKILL,
+		"PIPE":   syscall.SIGPIPE,
+		"PROF":   syscall.SIGPROF,
+		"PWR":    syscall.SIGPWR,
+		"QUIT":   syscall.SIGQUIT,
+		"STOP":   syscall.SIGSTOP,
+		"SYS":    syscall.SIGSYS,
+		"TERM":   syscall.SIGTERM,
+		"TRAP":   syscall.SIGTRAP,
+		"TSTP":   syscall.SIGTSTP,
+		"TTIN":   syscall.SIGTTIN,
+		"TTOU":   syscall.SIGTTOU,
+		"URG":    syscall.SIGURG,
+		"USR1":   syscall.SIGUSR1,
+		"USR2":   syscall.SIGUSR2,
+		"VTALRM": syscall.SIGVTALRM,
+		"WINCH":  syscall.SIGWINCH,
+		"XCPU":   syscall.SIGXCPU,
+		"XFSZ":   syscall.SIGXFSZ,
+	}
+
+	// unforwardableSignals 不能转发的信号，INT 和 TERM 用于关闭 minit，KILL 和 STOP 无法捕获，
+	// URG 被 Go 运行时频繁用于抢占调度，CHLD 用于回收子进程
+	unforwardableSignals = map[syscall.Signal]bool{
+		syscall.SIGINT:  true,
+		syscall.SIGTERM: true,
+		syscall.SIGKILL: true,
+		syscall.SIGSTOP: true,
+		syscall.SIGURG:  true,
+		syscall.SIGCHLD: true,
+	}
+)
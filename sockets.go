@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// listenSocket 监听 tcp://:8080, udp://:53, unix:///run/app.sock 格式的地址，返回阻塞模式的文件
+func listenSocket(address string) (file *os.File, err error) {
+	splits := strings.SplitN(address, "://", 2)
+	if len(splits) != 2 || splits[1] == "" {
+		err = fmt.Errorf("无效的套接字地址 %s，格式为 tcp://:8080 或者 unix:///run/app.sock", address)
+		return
+	}
+	network, addr := splits[0], splits[1]
+
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		var l net.Listener
+		if l, err = net.Listen(network, addr); err != nil {
+			return
+		}
+		defer l.Close()
+		file, err = l.(*net.TCPListener).File()
+	case "udp", "udp4", "udp6":
+		var c net.PacketConn
+		if c, err = net.ListenPacket(network, addr); err != nil {
+			return
+		}
+		defer c.Close()
+		file, err = c.(*net.UDPConn).File()
+	case "unix":
+		// 删除上次遗留的套接字文件，仍然可以连接时说明有其他进程在使用，不能删除
+		if info, err1 := os.Lstat(addr); err1 == nil && info.Mode()&os.ModeSocket != 0 {
+			if conn, err1 := net.DialTimeout(network, addr, time.Second); err1 == nil {
+				_ = conn.Close()
+				err = fmt.Errorf("套接字文件 %s 正在被其他进程使用", addr)
+				return
+			}
+			_ = os.Remove(addr)
+		}
+		var l *net.UnixListener
+		if l, err = net.ListenUnix(network, &net.UnixAddr{Name: addr, Net: network}); err != nil {
+			return
+		}
+		// 关闭监听器时保留套接字文件，由进程继续使用
+		l.SetUnlinkOnClose(false)
+		defer l.Close()
+		file, err = l.File()
+	default:
+		err = fmt.Errorf("不支持的套接字类型 %s，支持 tcp, tcp4, tcp6, udp, udp4, udp6, unix", network)
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	// 调用 Fd 会将文件设置为阻塞模式，与 systemd 的行为一致
+	_ = file.Fd()
+	return
+}
+
+func closeFiles(files []*os.File) {
+	for _, file := range files {
+		_ = file.Close()
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenSocket(t *testing.T) {
+	file, err := listenSocket("tcp://127.0.0.1:0")
+	require.NoError(t, err)
+	l, err := net.FileListener(file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	require.NoError(t, l.Close())
+
+	dir, err := ioutil.TempDir("", "minit-sockets")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	addr := filepath.Join(dir, "app.sock")
+	file, err = listenSocket("unix://" + addr)
+	require.NoError(t, err)
+	conn, err = net.Dial("unix", addr)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	// 仍然在使用的套接字文件不会被删除
+	_, err = listenSocket("unix://" + addr)
+	require.Error(t, err)
+	conn, err = net.Dial("unix", addr)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	require.NoError(t, file.Close())
+
+	// 遗留的套接字文件会被删除
+	file, err = listenSocket("unix://" + addr)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	_, err = listenSocket("127.0.0.1:8080")
+	require.Error(t, err)
+	_, err = listenSocket("sctp://127.0.0.1:8080")
+	require.Error(t, err)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	StdinNull    = "null"
+	StdinInherit = "inherit"
+	StdinFile    = "file"
+	StdinData    = "data"
+)
+
+// StdinOptions 进程的标准输入，yaml 中可以写作 null, inherit, {file: 路径} 或者 {data: 内容}
+type StdinOptions struct {
+	Mode string // 为空表示未设置，与显式设置为 null 不同
+	File string
+	Data string
+}
+
+// parseStdin 解析 yaml 中 stdin 字段的原始值，nil 表示显式设置为 null
+func parseStdin(val interface{}) (s StdinOptions, err error) {
+	switch v := val.(type) {
+	case nil:
+		s.Mode = StdinNull
+	case string:
+		mode := strings.ToLower(strings.TrimSpace(v))
+		if mode != StdinNull && mode != StdinInherit {
+			err = fmt.Errorf("无效的 stdin: %s，可选值为 null, inherit, file 或者 data", v)
+			return
+		}
+		s.Mode = mode
+	case map[interface{}]interface{}:
+		if len(v) != 1 {
+			err = fmt.Errorf("stdin 必须且只能设置 file 或者 data 中的一个")
+			return
+		}
+		for key, item := range v {
+			str, ok := item.(string)
+			if !ok {
+				err = fmt.Errorf("stdin 的 %v 必须为字符串", key)
+				return
+			}
+			switch key {
+			case StdinFile:
+				s = StdinOptions{Mode: StdinFile, File: str}
+			case StdinData:
+				s = StdinOptions{Mode: StdinData, Data: str}
+			default:
+				err = fmt.Errorf("stdin 必须且只能设置 file 或者 data 中的一个")
+			}
+		}
+	default:
+		err = fmt.Errorf("无效的 stdin，格式为 null, inherit, {file: 路径} 或者 {data: 内容}")
+	}
+	return
+}
+
+// open 打开标准输入，返回 nil 表示 /dev/null，cleanup 需要在进程启动后调用
+func (s StdinOptions) open(dir string, env *environment) (r io.Reader, cleanup func(), err error) {
+	cleanup = func() {}
+	switch s.Mode {
+	case "", StdinNull:
+	case StdinInherit:
+		r = os.Stdin
+	case StdinFile:
+		// 相对路径以 dir 为基准
+		name := env.Expand(s.File)
+		if !filepath.IsAbs(name) && dir != "" {
+			name = filepath.Join(dir, name)
+		}
+		var f *os.File
+		if f, err = os.Open(name); err != nil {
+			return
+		}
+		r = f
+		cleanup = func() { _ = f.Close() }
+	case StdinData:
+		r = strings.NewReader(s.Data)
+	default:
+		err = fmt.Errorf("无效的 stdin: %s，可选值为 null, inherit, file 或者 data", s.Mode)
+	}
+	return
+}
+
+// openScript 通过管道传递 shell 命令，进程读取完毕或者管道关闭后，写入协程退出；
+// 进程通过 /dev/fd/N 重新打开管道，切换用户时需要将管道的所有者修改为目标用户，否则没有权限
+func openScript(script string, cred *credential) (r *os.File, err error) {
+	var w *os.File
+	if r, w, err = os.Pipe(); err != nil {
+		return
+	}
+	if cred != nil {
+		if err = r.Chown(int(cred.Uid), int(cred.Gid)); err != nil {
+			_ = r.Close()
+			_ = w.Close()
+			return
+		}
+	}
+	go func() {
+		_, _ = io.WriteString(w, script)
+		_ = w.Close()
+	}()
+	return
+}
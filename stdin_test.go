@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnitUnmarshalYAMLStdin(t *testing.T) {
+	for doc, expected := range map[string]StdinOptions{
+		"name: a":                                 {},
+		"name: a\nstdin: null":                    {Mode: StdinNull},
+		"name: a\nstdin: ~":                       {Mode: StdinNull},
+		"name: a\nstdin: \"null\"":                {Mode: StdinNull},
+		"name: a\nstdin: inherit":                 {Mode: StdinInherit},
+		"name: a\nstdin:\n  file: /tmp/a":         {Mode: StdinFile, File: "/tmp/a"},
+		"name: a\nstdin:\n  data: |\n    hello\n": {Mode: StdinData, Data: "hello\n"},
+	} {
+		var unit Unit
+		require.NoError(t, yaml.Unmarshal([]byte(doc), &unit), doc)
+		require.Equal(t, "a", unit.Name, doc)
+		require.Equal(t, expected, unit.Stdin, doc)
+	}
+	for _, doc := range []string{
+		"stdin: console",
+		"stdin: {file: a, data: b}",
+		"stdin: {}",
+		"stdin: {file: [a]}",
+		"stdin: [a]",
+	} {
+		var unit Unit
+		require.Error(t, yaml.Unmarshal([]byte(doc), &unit), doc)
+	}
+}
+
+func TestStdinOptionsOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "minit-stdin")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "input"), []byte("file"), 0644))
+
+	env := newEnvironment()
+	env.Set("NAME", "input")
+
+	r, cleanup, err := StdinOptions{Mode: StdinFile, File: "$NAME"}.open(dir, env)
+	require.NoError(t, err)
+	buf, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "file", string(buf))
+	cleanup()
+
+	r, cleanup, err = StdinOptions{Mode: StdinData, Data: "data"}.open(dir, env)
+	require.NoError(t, err)
+	buf, err = ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "data", string(buf))
+	cleanup()
+
+	r, _, err = StdinOptions{}.open(dir, env)
+	require.NoError(t, err)
+	require.Nil(t, r)
+
+	_, _, err = StdinOptions{Mode: StdinFile, File: "missing"}.open(dir, env)
+	require.Error(t, err)
+}
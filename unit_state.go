@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+var (
+	unitReadies                 = map[string]chan struct{}{}
+	unitReadiesLock sync.Locker = &sync.Mutex{}
+)
+
+func unitReadyChan(name string) chan struct{} {
+	unitReadiesLock.Lock()
+	defer unitReadiesLock.Unlock()
+	ch := unitReadies[name]
+	if ch == nil {
+		ch = make(chan struct{})
+		unitReadies[name] = ch
+	}
+	return ch
+}
+
+// markUnitReady 标记单元已经就绪，依赖此单元的单元可以开始启动
+func markUnitReady(name string) {
+	ch := unitReadyChan(name)
+	unitReadiesLock.Lock()
+	defer unitReadiesLock.Unlock()
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+}
+
+// waitUnitReady 等待单元就绪，ctx 结束时返回 false
+func waitUnitReady(ctx context.Context, name string) bool {
+	select {
+	case <-unitReadyChan(name):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}